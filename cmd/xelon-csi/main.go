@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"os"
+	"strings"
 	"time"
 
 	"k8s.io/component-base/featuregate"
@@ -17,13 +18,21 @@ import (
 
 // command line flags
 var (
-	endpoint       = flag.String("endpoint", "unix:///var/lib/kubelet/plugins/csi.xelon.ch/csi.sock", "CSI endpoint")
-	mode           = flag.String("mode", string(driverv1.AllMode), "The mode in which the CSI driver will be run (all, node, controller)")
-	rescanOnResize = flag.Bool("rescan-on-resize", true, "Rescan block device and verify its size before expanding the filesystem (node mode)")
-	xelonBaseURL   = flag.String("xelon-base-url", "https://vdc.xelon.ch/api/service/", "Xelon API URL")
-	xelonClientID  = flag.String("xelon-client-id", "", "Xelon client ID for IP ranges")
-	xelonCloudID   = flag.String("xelon-cloud-id", "", "Xelon client ID for IP ranges")
-	xelonToken     = flag.String("xelon-token", "", "Xelon access token")
+	endpoint          = flag.String("endpoint", "unix:///var/lib/kubelet/plugins/csi.xelon.ch/csi.sock", "CSI endpoint")
+	maxVolumesPerNode = flag.Int("max-volumes-per-node", driverv1.DefaultMaxVolumesPerNode, "Maximum number of persistent storages attached to a single device, enforced by the controller and reported by the node, which can be overridden per node with the "+driverv1.LabelMaxVolumesPerNode+" label")
+	mode              = flag.String("mode", string(driverv1.AllMode), "The mode in which the CSI driver will be run (all, node, controller)")
+	rescanOnResize    = flag.Bool("rescan-on-resize", true, "Rescan block device and verify its size before expanding the filesystem (node mode)")
+	xelonBaseURL      = flag.String("xelon-base-url", "https://vdc.xelon.ch/api/service/", "Xelon API URL")
+	xelonClientID     = flag.String("xelon-client-id", "", "Xelon client ID for IP ranges")
+	xelonCloudID      = flag.String("xelon-cloud-id", "", "Comma-separated Xelon cloud IDs to create volumes in, the first one is the default (controller mode), or the Xelon cloud of the node if it has no "+driverv1.LabelXelonCloudID+" label (node mode)")
+	xelonToken        = flag.String("xelon-token", "", "Xelon access token")
+
+	xelonAPIMaxInFlight     = flag.Int("xelon-api-max-in-flight", 10, "Maximum number of concurrent requests to the Xelon API, 0 means unlimited")
+	xelonAPIMaxRetries      = flag.Int("xelon-api-max-retries", 5, "Maximum number of retries of a failed request to the Xelon API, 0 disables retries")
+	xelonAPIRateBurst       = flag.Int("xelon-api-rate-burst", 10, "Maximum burst of requests to the Xelon API above the rate limit")
+	xelonAPIRateLimit       = flag.Float64("xelon-api-rate-limit", 5, "Maximum number of requests per second to the Xelon API, 0 disables rate limiting")
+	xelonAPIRetryMaxBackoff = flag.Duration("xelon-api-retry-max-backoff", 30*time.Second, "Maximum backoff between retries of a failed request to the Xelon API")
+	xelonAPIRetryMinBackoff = flag.Duration("xelon-api-retry-min-backoff", 500*time.Millisecond, "Initial backoff between retries of a failed request to the Xelon API")
 )
 
 func main() {
@@ -49,13 +58,21 @@ func main() {
 	d, err := driverv1.NewDriver(
 		ctx,
 		&driverv1.Options{
-			Endpoint:       *endpoint,
-			Mode:           driverv1.Mode(*mode),
-			RescanOnResize: *rescanOnResize,
-			XelonBaseURL:   *xelonBaseURL,
-			XelonClientID:  *xelonClientID,
-			XelonCloudID:   *xelonCloudID,
-			XelonToken:     *xelonToken,
+			Endpoint:          *endpoint,
+			MaxVolumesPerNode: *maxVolumesPerNode,
+			Mode:              driverv1.Mode(*mode),
+			RescanOnResize:    *rescanOnResize,
+			XelonBaseURL:      *xelonBaseURL,
+			XelonClientID:     *xelonClientID,
+			XelonCloudIDs:     splitCloudIDs(*xelonCloudID),
+			XelonToken:        *xelonToken,
+
+			XelonAPIMaxInFlight:     *xelonAPIMaxInFlight,
+			XelonAPIMaxRetries:      *xelonAPIMaxRetries,
+			XelonAPIRateBurst:       *xelonAPIRateBurst,
+			XelonAPIRateLimit:       *xelonAPIRateLimit,
+			XelonAPIRetryMaxBackoff: *xelonAPIRetryMaxBackoff,
+			XelonAPIRetryMinBackoff: *xelonAPIRetryMinBackoff,
 		},
 	)
 	if err != nil {
@@ -68,3 +85,14 @@ func main() {
 		os.Exit(255)
 	}
 }
+
+// splitCloudIDs splits comma-separated cloud ids and drops empty entries.
+func splitCloudIDs(value string) []string {
+	var cloudIDs []string
+	for _, cloudID := range strings.Split(value, ",") {
+		if cloudID = strings.TrimSpace(cloudID); cloudID != "" {
+			cloudIDs = append(cloudIDs, cloudID)
+		}
+	}
+	return cloudIDs
+}
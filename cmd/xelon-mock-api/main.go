@@ -0,0 +1,115 @@
+// Command xelon-mock-api serves a stand-in for the Xelon service API, so that the driver can run
+// with --xelon-base-url pointing to it, e.g. in kind clusters without access to Xelon.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud/fake"
+	"github.com/Xelon-AG/xelon-csi/internal/xelonmock"
+)
+
+// command line flags
+var (
+	basePath           = flag.String("base-path", "/api/service/", "Path under which the API is served")
+	cassette           = flag.String("cassette", "", "Cassette file to replay (replay mode) or to record to (record mode)")
+	formattingDuration = flag.Duration("formatting-duration", 10*time.Second, "Time until a created or extended persistent storage is formatted (simulate mode)")
+	latency            = flag.Duration("latency", 0, "Delay of every request (simulate mode)")
+	listen             = flag.String("listen", ":8080", "Address to listen on")
+	mode               = flag.String("mode", "simulate", "The mode in which the server will be run (simulate, record, replay)")
+	state              = flag.String("state", "", "JSON file with the clouds, devices and persistent storages of the tenant (simulate mode)")
+	target             = flag.String("target", "https://vdc.xelon.ch/api/service/", "Xelon API URL to record (record mode)")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Parse()
+	defer klog.Flush()
+
+	var handler http.Handler
+	var recorded *xelonmock.Cassette
+	switch *mode {
+	case "simulate":
+		p := fake.NewProvider()
+		p.FormattingDuration = *formattingDuration
+		p.Latency = *latency
+		if *state != "" {
+			s, err := xelonmock.LoadState(*state)
+			if err != nil {
+				klog.ErrorS(err, "Failed to load state")
+				os.Exit(1)
+			}
+			s.Apply(p)
+		}
+		klog.InfoS("Simulating Xelon API", "tenantID", p.TenantID())
+		handler = xelonmock.NewHandler(p)
+	case "record":
+		if *cassette == "" {
+			klog.ErrorS(nil, "Cassette file is required in record mode")
+			os.Exit(1)
+		}
+		targetURL, err := url.Parse(*target)
+		if err != nil {
+			klog.ErrorS(err, "Failed to parse target URL")
+			os.Exit(1)
+		}
+		recorded = &xelonmock.Cassette{}
+		klog.InfoS("Recording Xelon API", "target", targetURL.Redacted())
+		handler = xelonmock.NewRecorder(targetURL, recorded)
+	case "replay":
+		c, err := xelonmock.LoadCassette(*cassette)
+		if err != nil {
+			klog.ErrorS(err, "Failed to load cassette")
+			os.Exit(1)
+		}
+		klog.InfoS("Replaying Xelon API", "cassette", *cassette, "interactions", len(c.Interactions))
+		handler = xelonmock.NewReplayer(c)
+	default:
+		klog.ErrorS(nil, "Unsupported mode", "mode", *mode)
+		os.Exit(1)
+	}
+
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           http.StripPrefix(strings.TrimSuffix(*basePath, "/"), handler),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	klog.InfoS("Listening for connections", "address", *listen, "basePath", *basePath, "mode", *mode)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.ErrorS(err, "Failed to serve")
+		os.Exit(1)
+	}
+	// wait for in-flight requests, so that their interactions are recorded
+	<-shutdown
+
+	if recorded != nil {
+		if err := recorded.Save(*cassette); err != nil {
+			klog.ErrorS(err, "Failed to save cassette")
+			os.Exit(1)
+		}
+		klog.InfoS("Saved cassette", "cassette", *cassette, "interactions", len(recorded.Interactions))
+	}
+}
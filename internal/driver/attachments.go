@@ -0,0 +1,69 @@
+package driver
+
+import (
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// attachmentModes keeps track of the access modes volumes are published to devices with. Xelon
+// attaches every persistent storage read/write and doesn't know about access modes, so the
+// controller relies on them to attach a volume to further devices only if none of them writes
+// to it. The modes are kept in memory only: after a restart, the modes of the existing attachments
+// are unknown until they are published again or detached.
+type attachmentModes struct {
+	mu sync.Mutex
+	// modes maps volume ids to the access mode per device id
+	modes map[string]map[string]csi.VolumeCapability_AccessMode_Mode
+}
+
+func newAttachmentModes() *attachmentModes {
+	return &attachmentModes{
+		modes: make(map[string]map[string]csi.VolumeCapability_AccessMode_Mode),
+	}
+}
+
+// get returns the access mode the volume is published to the device with, if it is known.
+func (a *attachmentModes) get(volumeID, nodeID string) (csi.VolumeCapability_AccessMode_Mode, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	mode, ok := a.modes[volumeID][nodeID]
+	return mode, ok
+}
+
+// set records the access mode the volume is published to the device with.
+func (a *attachmentModes) set(volumeID, nodeID string, mode csi.VolumeCapability_AccessMode_Mode) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.modes[volumeID]; !ok {
+		a.modes[volumeID] = make(map[string]csi.VolumeCapability_AccessMode_Mode)
+	}
+	a.modes[volumeID][nodeID] = mode
+}
+
+// remove forgets the access mode of an unpublished volume.
+func (a *attachmentModes) remove(volumeID, nodeID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.modes[volumeID], nodeID)
+	if len(a.modes[volumeID]) == 0 {
+		delete(a.modes, volumeID)
+	}
+}
+
+// readOnly returns true if the volume is known to be published read-only to all given devices.
+func (a *attachmentModes) readOnly(volumeID string, nodeIDs []string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, nodeID := range nodeIDs {
+		mode, ok := a.modes[volumeID][nodeID]
+		if !ok || !isReadOnlyAccessMode(mode) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestAttachmentModes_readOnly(t *testing.T) {
+	attachments := newAttachmentModes()
+
+	attachments.set("vol-1", "dev-1", csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)
+	attachments.set("vol-1", "dev-2", csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY)
+	if !attachments.readOnly("vol-1", []string{"dev-1", "dev-2"}) {
+		t.Error("expected vol-1 to be published read-only to dev-1 and dev-2")
+	}
+	if attachments.readOnly("vol-1", []string{"dev-1", "dev-3"}) {
+		t.Error("expected the unknown mode of dev-3 not to be read-only")
+	}
+
+	attachments.set("vol-1", "dev-2", csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	if attachments.readOnly("vol-1", []string{"dev-1", "dev-2"}) {
+		t.Error("expected the writer on dev-2 not to be read-only")
+	}
+
+	attachments.remove("vol-1", "dev-1")
+	attachments.remove("vol-1", "dev-2")
+	if _, ok := attachments.get("vol-1", "dev-1"); ok {
+		t.Error("expected the mode of the removed attachment to be unknown")
+	}
+	if len(attachments.modes) != 0 {
+		t.Errorf("expected volumes without attachments to be dropped, got %v", attachments.modes)
+	}
+}
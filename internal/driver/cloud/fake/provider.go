@@ -0,0 +1,541 @@
+// Package fake provides an in-memory Xelon backend which implements cloud.Provider, so that the
+// driver can be tested without access to the Xelon API.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud"
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+// Method names of cloud.Provider, used to inject failures and count calls.
+const (
+	MethodGetCurrentTenant           = "GetCurrentTenant"
+	MethodListClouds                 = "ListClouds"
+	MethodGetDevice                  = "GetDevice"
+	MethodListPersistentStorages     = "ListPersistentStorages"
+	MethodGetPersistentStorage       = "GetPersistentStorage"
+	MethodGetPersistentStorageByName = "GetPersistentStorageByName"
+	MethodCreatePersistentStorage    = "CreatePersistentStorage"
+	MethodExtendPersistentStorage    = "ExtendPersistentStorage"
+	MethodDeletePersistentStorage    = "DeletePersistentStorage"
+	MethodAttachPersistentStorage    = "AttachPersistentStorage"
+	MethodDetachPersistentStorage    = "DetachPersistentStorage"
+)
+
+// DefaultTenantID is the tenant of a new Provider.
+const DefaultTenantID = "fake-tenant"
+
+// Compile-time check to ensure that Provider satisfies the cloud.Provider interface.
+var _ cloud.Provider = &Provider{}
+
+// Provider is an in-memory Xelon backend. Like Xelon, it creates and extends persistent storages
+// asynchronously: until FormattingDuration has passed, a new storage reports an empty uuid and
+// formatted 0, and an extended storage still reports its previous capacity.
+//
+// Failed calls return a *xelon.ErrorResponse with the status code Xelon would respond with.
+type Provider struct {
+	// Latency delays every call, e.g. to exercise timeouts of the driver.
+	Latency time.Duration
+	// FormattingDuration is the time Xelon needs to create or extend a persistent storage.
+	FormattingDuration time.Duration
+
+	mu       sync.Mutex
+	tenantID string
+	clouds   map[int]xelon.Cloud
+	devices  map[string]*xelon.DeviceRoot
+	storages map[string]*persistentStorage
+	failures map[string]*failure
+	calls    map[string]int
+	lastID   int
+}
+
+type persistentStorage struct {
+	xelon.PersistentStorage
+
+	// readyAt is the time at which a pending create or extend completes
+	readyAt         time.Time
+	pendingCapacity int
+	pendingUUID     string
+}
+
+type failure struct {
+	statusCode int
+	// times is the number of calls which still fail, a negative value fails all calls
+	times int
+}
+
+// NewProvider creates an empty backend for the DefaultTenantID.
+func NewProvider() *Provider {
+	return &Provider{
+		tenantID: DefaultTenantID,
+		clouds:   make(map[int]xelon.Cloud),
+		devices:  make(map[string]*xelon.DeviceRoot),
+		storages: make(map[string]*persistentStorage),
+		failures: make(map[string]*failure),
+		calls:    make(map[string]int),
+	}
+}
+
+// TenantID returns the tenant of the backend.
+func (p *Provider) TenantID() string {
+	return p.tenantID
+}
+
+// AddCloud adds a cloud accessible by the tenant.
+func (p *Provider) AddCloud(id int, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clouds[id] = xelon.Cloud{ID: id, Name: name}
+}
+
+// AddDevice adds a device which is running in the given cloud.
+func (p *Provider) AddDevice(localVMID string, cloudID int, poweredOn bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.devices[localVMID] = &xelon.DeviceRoot{
+		Device: &xelon.Device{
+			LocalVMDetails: &xelon.DeviceLocalVMDetails{
+				HVSystemID:    cloudID,
+				LocalVMID:     localVMID,
+				VMDisplayName: localVMID,
+				VMHostname:    localVMID,
+			},
+			PowerState: poweredOn,
+		},
+	}
+}
+
+// SetDevicePowerState powers the given device on or off.
+func (p *Provider) SetDevicePowerState(localVMID string, poweredOn bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if device, ok := p.devices[localVMID]; ok {
+		device.Device.PowerState = poweredOn
+	}
+}
+
+// RemoveDevice deletes the given device without detaching its persistent storages, like Xelon
+// does after a device was deleted.
+func (p *Provider) RemoveDevice(localVMID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.devices, localVMID)
+}
+
+// AddPersistentStorage adds a ready persistent storage and returns it with its generated ids.
+func (p *Provider) AddPersistentStorage(storage xelon.PersistentStorage) xelon.PersistentStorage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastID++
+	storage.ID = p.lastID
+	if storage.LocalID == "" {
+		storage.LocalID = localID(p.lastID)
+	}
+	if storage.UUID == "" {
+		storage.UUID = uuid(p.lastID)
+	}
+	storage.Formatted = 1
+	p.storages[storage.LocalID] = &persistentStorage{PersistentStorage: storage}
+	return copyPersistentStorage(storage)
+}
+
+// PersistentStorage returns the current state of the given persistent storage.
+func (p *Provider) PersistentStorage(localID string) (xelon.PersistentStorage, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	storage, ok := p.storages[localID]
+	if !ok {
+		return xelon.PersistentStorage{}, false
+	}
+	return storage.current(), true
+}
+
+// InjectFailure makes the next times calls of the given method fail with the given HTTP status
+// code. A negative times fails all calls until ClearFailures is called.
+func (p *Provider) InjectFailure(method string, statusCode, times int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures[method] = &failure{statusCode: statusCode, times: times}
+}
+
+// ClearFailures removes all injected failures.
+func (p *Provider) ClearFailures() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures = make(map[string]*failure)
+}
+
+// Calls returns the number of calls of the given method.
+func (p *Provider) Calls(method string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.calls[method]
+}
+
+func (p *Provider) GetCurrentTenant(ctx context.Context) (*xelon.Tenant, error) {
+	if err := p.call(ctx, MethodGetCurrentTenant, http.MethodGet, "tenant"); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return &xelon.Tenant{TenantID: p.tenantID}, nil
+}
+
+func (p *Provider) ListClouds(ctx context.Context, tenantID string) ([]xelon.Cloud, error) {
+	if err := p.call(ctx, MethodListClouds, http.MethodGet, "hv/list/"+tenantID); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if tenantID != p.tenantID {
+		return nil, newErrorResponse(http.MethodGet, "hv/list/"+tenantID, http.StatusForbidden)
+	}
+	clouds := make([]xelon.Cloud, 0, len(p.clouds))
+	for _, c := range p.clouds {
+		clouds = append(clouds, c)
+	}
+	sort.Slice(clouds, func(i, j int) bool {
+		return clouds[i].ID < clouds[j].ID
+	})
+	return clouds, nil
+}
+
+func (p *Provider) GetDevice(ctx context.Context, tenantID, localVMID string) (*xelon.DeviceRoot, error) {
+	path := "device?tenant=" + tenantID + "&localvmid=" + localVMID
+	if err := p.call(ctx, MethodGetDevice, http.MethodGet, path); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	device, ok := p.devices[localVMID]
+	if !ok || tenantID != p.tenantID {
+		return nil, newErrorResponse(http.MethodGet, path, http.StatusNotFound)
+	}
+	details := *device.Device.LocalVMDetails
+	return &xelon.DeviceRoot{
+		Device: &xelon.Device{
+			LocalVMDetails: &details,
+			PowerState:     device.Device.PowerState,
+		},
+	}, nil
+}
+
+func (p *Provider) ListPersistentStorages(ctx context.Context, tenantID string) ([]xelon.PersistentStorage, error) {
+	path := tenantID + "/persistentStorage"
+	if err := p.call(ctx, MethodListPersistentStorages, http.MethodGet, path); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if tenantID != p.tenantID {
+		return nil, newErrorResponse(http.MethodGet, path, http.StatusForbidden)
+	}
+	storages := make([]xelon.PersistentStorage, 0, len(p.storages))
+	for _, storage := range p.storages {
+		storages = append(storages, storage.current())
+	}
+	sort.Slice(storages, func(i, j int) bool {
+		return storages[i].ID < storages[j].ID
+	})
+	return storages, nil
+}
+
+func (p *Provider) GetPersistentStorage(ctx context.Context, tenantID, localID string) (*xelon.PersistentStorage, error) {
+	path := tenantID + "/persistentStorage/" + localID
+	if err := p.call(ctx, MethodGetPersistentStorage, http.MethodGet, path); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	storage, ok := p.storages[localID]
+	if !ok || tenantID != p.tenantID {
+		return nil, newErrorResponse(http.MethodGet, path, http.StatusNotFound)
+	}
+	current := storage.current()
+	return &current, nil
+}
+
+func (p *Provider) GetPersistentStorageByName(ctx context.Context, tenantID, name string) (*xelon.PersistentStorage, error) {
+	path := tenantID + "/persistentStorage/query?name=" + name
+	if err := p.call(ctx, MethodGetPersistentStorageByName, http.MethodGet, path); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if tenantID == p.tenantID {
+		for _, storage := range p.storages {
+			if storage.Name == name {
+				current := storage.current()
+				return &current, nil
+			}
+		}
+	}
+	return nil, newErrorResponse(http.MethodGet, path, http.StatusNotFound)
+}
+
+func (p *Provider) CreatePersistentStorage(ctx context.Context, tenantID string, createRequest *xelon.PersistentStorageCreateRequest) (*xelon.APIResponse, error) {
+	path := tenantID + "/persistentStorage"
+	if err := p.call(ctx, MethodCreatePersistentStorage, http.MethodPost, path); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if tenantID != p.tenantID {
+		return nil, newErrorResponse(http.MethodPost, path, http.StatusForbidden)
+	}
+	if createRequest == nil || createRequest.PersistentStorage == nil || createRequest.Name == "" || createRequest.Size <= 0 {
+		return nil, newErrorResponse(http.MethodPost, path, http.StatusUnprocessableEntity)
+	}
+	cloudID, err := strconv.Atoi(createRequest.CloudID)
+	if _, ok := p.clouds[cloudID]; err != nil || !ok {
+		return nil, newErrorResponse(http.MethodPost, path, http.StatusUnprocessableEntity)
+	}
+	for _, storage := range p.storages {
+		if storage.Name == createRequest.Name {
+			return nil, newErrorResponse(http.MethodPost, path, http.StatusUnprocessableEntity)
+		}
+	}
+
+	p.lastID++
+	storage := &persistentStorage{
+		PersistentStorage: xelon.PersistentStorage{
+			ID:      p.lastID,
+			LocalID: localID(p.lastID),
+			Name:    createRequest.Name,
+			Type:    createRequest.Type,
+		},
+		readyAt:         time.Now().Add(p.FormattingDuration),
+		pendingCapacity: createRequest.Size,
+		pendingUUID:     uuid(p.lastID),
+	}
+	p.storages[storage.LocalID] = storage
+
+	current := storage.current()
+	return &xelon.APIResponse{
+		Message:           "Persistent storage is being created",
+		PersistentStorage: &current,
+	}, nil
+}
+
+func (p *Provider) ExtendPersistentStorage(ctx context.Context, localID string, extendRequest *xelon.PersistentStorageExtendRequest) (*xelon.APIResponse, error) {
+	path := "persistentStorage/" + localID
+	if err := p.call(ctx, MethodExtendPersistentStorage, http.MethodPost, path); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	storage, ok := p.storages[localID]
+	if !ok {
+		return nil, newErrorResponse(http.MethodPost, path, http.StatusNotFound)
+	}
+	storage.complete()
+	if extendRequest == nil || extendRequest.Size <= storage.Capacity || !storage.readyAt.IsZero() {
+		return nil, newErrorResponse(http.MethodPost, path, http.StatusUnprocessableEntity)
+	}
+	storage.readyAt = time.Now().Add(p.FormattingDuration)
+	storage.pendingCapacity = extendRequest.Size
+	storage.pendingUUID = storage.UUID
+
+	current := storage.current()
+	return &xelon.APIResponse{
+		Message:           "Persistent storage is being extended",
+		PersistentStorage: &current,
+	}, nil
+}
+
+func (p *Provider) DeletePersistentStorage(ctx context.Context, tenantID, localID string) error {
+	path := tenantID + "/persistentStorage/" + localID
+	if err := p.call(ctx, MethodDeletePersistentStorage, http.MethodDelete, path); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	storage, ok := p.storages[localID]
+	if !ok || tenantID != p.tenantID {
+		return newErrorResponse(http.MethodDelete, path, http.StatusNotFound)
+	}
+	if len(storage.AssignedServers) > 0 {
+		return newErrorResponse(http.MethodDelete, path, http.StatusUnprocessableEntity)
+	}
+	delete(p.storages, localID)
+	return nil
+}
+
+func (p *Provider) AttachPersistentStorage(ctx context.Context, tenantID, localID string, attachRequest *xelon.PersistentStorageAttachDetachRequest) (*xelon.APIResponse, error) {
+	path := tenantID + "/persistentStorage/" + localID + "/addToVirtualMachine"
+	if err := p.call(ctx, MethodAttachPersistentStorage, http.MethodPost, path); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	storage, ok := p.storages[localID]
+	if !ok || tenantID != p.tenantID {
+		return nil, newErrorResponse(http.MethodPost, path, http.StatusNotFound)
+	}
+	if attachRequest == nil || len(attachRequest.ServerID) == 0 {
+		return nil, newErrorResponse(http.MethodPost, path, http.StatusUnprocessableEntity)
+	}
+	for _, serverID := range attachRequest.ServerID {
+		device, ok := p.devices[serverID]
+		if !ok {
+			return nil, newErrorResponse(http.MethodPost, path, http.StatusNotFound)
+		}
+		if !storage.isAttachedTo(serverID) {
+			storage.AssignedServers = append(storage.AssignedServers, *device.Device.LocalVMDetails)
+		}
+	}
+
+	current := storage.current()
+	return &xelon.APIResponse{
+		Message:           "Persistent storage is attached",
+		PersistentStorage: &current,
+	}, nil
+}
+
+func (p *Provider) DetachPersistentStorage(ctx context.Context, tenantID, localID string, detachRequest *xelon.PersistentStorageAttachDetachRequest) (*xelon.APIResponse, error) {
+	path := tenantID + "/persistentStorage/" + localID + "/removeFromVirtualMachine"
+	if err := p.call(ctx, MethodDetachPersistentStorage, http.MethodPost, path); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	storage, ok := p.storages[localID]
+	if !ok || tenantID != p.tenantID {
+		return nil, newErrorResponse(http.MethodPost, path, http.StatusNotFound)
+	}
+	if detachRequest == nil || len(detachRequest.ServerID) == 0 {
+		return nil, newErrorResponse(http.MethodPost, path, http.StatusUnprocessableEntity)
+	}
+	for _, serverID := range detachRequest.ServerID {
+		assignedServers := storage.AssignedServers[:0]
+		for _, server := range storage.AssignedServers {
+			if server.LocalVMID != serverID {
+				assignedServers = append(assignedServers, server)
+			}
+		}
+		storage.AssignedServers = assignedServers
+	}
+
+	current := storage.current()
+	return &xelon.APIResponse{
+		Message:           "Persistent storage is detached",
+		PersistentStorage: &current,
+	}, nil
+}
+
+// call counts the call, waits for the configured latency and returns an injected failure.
+func (p *Provider) call(ctx context.Context, method, httpMethod, path string) error {
+	p.mu.Lock()
+	p.calls[method]++
+	latency := p.Latency
+	var statusCode int
+	if f, ok := p.failures[method]; ok && f.times != 0 {
+		statusCode = f.statusCode
+		if f.times > 0 {
+			f.times--
+		}
+	}
+	p.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if statusCode != 0 {
+		return newErrorResponse(httpMethod, path, statusCode)
+	}
+	return nil
+}
+
+// current completes a pending operation if it is due and returns a copy of the storage.
+func (s *persistentStorage) current() xelon.PersistentStorage {
+	if !s.readyAt.IsZero() && !time.Now().Before(s.readyAt) {
+		s.complete()
+	}
+	return copyPersistentStorage(s.PersistentStorage)
+}
+
+// complete finishes a pending operation once it is due.
+func (s *persistentStorage) complete() {
+	if s.readyAt.IsZero() || time.Now().Before(s.readyAt) {
+		return
+	}
+	s.Capacity = s.pendingCapacity
+	s.UUID = s.pendingUUID
+	s.Formatted = 1
+	s.readyAt = time.Time{}
+}
+
+func (s *persistentStorage) isAttachedTo(localVMID string) bool {
+	for _, server := range s.AssignedServers {
+		if server.LocalVMID == localVMID {
+			return true
+		}
+	}
+	return false
+}
+
+func copyPersistentStorage(storage xelon.PersistentStorage) xelon.PersistentStorage {
+	storage.AssignedServers = append([]xelon.DeviceLocalVMDetails(nil), storage.AssignedServers...)
+	return storage
+}
+
+// newErrorResponse returns the error the Xelon SDK returns for a response with the given status.
+func newErrorResponse(method, path string, statusCode int) error {
+	req, _ := http.NewRequest(method, "https://fake.xelon.local/api/service/"+path, nil)
+	return &xelon.ErrorResponse{
+		Response: &xelon.Response{
+			Response: &http.Response{
+				Header:     http.Header{},
+				Request:    req,
+				Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+				StatusCode: statusCode,
+			},
+		},
+	}
+}
+
+func localID(id int) string {
+	return fmt.Sprintf("fake%08x", id)
+}
+
+func uuid(id int) string {
+	return fmt.Sprintf("6000c29%025x", id)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -15,12 +16,36 @@ import (
 const (
 	LabelXelonLocalVMIDDeprecated = "kubernetes.xelon.ch/localvmid"
 	LabelXelonLocalVMID           = "node.kubernetes.io/localvmid"
+	LabelMaxVolumesPerNode        = "csi.xelon.ch/max-volumes-per-node"
+	LabelXelonCloudID             = "csi.xelon.ch/cloud-id"
 )
 
 // Metadata is info about the Xelon Device on which driver is running
 type Metadata struct {
 	LocalVMID string
 	Name      string
+
+	// CloudID is the Xelon cloud of the device set from the node label, empty if not set
+	CloudID string
+	// MaxVolumesPerNode is set from the node label, 0 means not set
+	MaxVolumesPerNode int
+}
+
+// cloudIDFromLabels returns the Xelon cloud set by the node label, or an empty string if it is not
+// set. An invalid label is ignored, so that a typo doesn't prevent the node plugin from starting.
+func cloudIDFromLabels(labels map[string]string) string {
+	value, ok := labels[LabelXelonCloudID]
+	if !ok {
+		return ""
+	}
+	if _, err := strconv.Atoi(value); err != nil {
+		klog.ErrorS(err, "Ignoring invalid node label, it must be a numeric cloud id",
+			"label", LabelXelonCloudID,
+			"value", value,
+		)
+		return ""
+	}
+	return value
 }
 
 func RetrieveMetadata(ctx context.Context) (*Metadata, error) {
@@ -58,5 +83,26 @@ func RetrieveMetadata(ctx context.Context) (*Metadata, error) {
 		}
 	}
 
+	metadata.CloudID = cloudIDFromLabels(node.GetLabels())
+	metadata.MaxVolumesPerNode = maxVolumesPerNodeFromLabels(node.GetLabels())
+
 	return metadata, nil
 }
+
+// maxVolumesPerNodeFromLabels returns the limit set by the node label, or 0 if it is not set. An
+// invalid label is ignored, so that a typo doesn't prevent the node plugin from starting.
+func maxVolumesPerNodeFromLabels(labels map[string]string) int {
+	value, ok := labels[LabelMaxVolumesPerNode]
+	if !ok {
+		return 0
+	}
+	maxVolumesPerNode, err := strconv.Atoi(value)
+	if err != nil || maxVolumesPerNode <= 0 {
+		klog.ErrorS(err, "Ignoring invalid node label, it must be a positive integer",
+			"label", LabelMaxVolumesPerNode,
+			"value", value,
+		)
+		return 0
+	}
+	return maxVolumesPerNode
+}
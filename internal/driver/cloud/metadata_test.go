@@ -0,0 +1,43 @@
+package cloud
+
+import "testing"
+
+func TestCloudIDFromLabels(t *testing.T) {
+	tests := map[string]struct {
+		labels map[string]string
+		want   string
+	}{
+		"not set": {labels: map[string]string{}, want: ""},
+		"valid":   {labels: map[string]string{LabelXelonCloudID: "2"}, want: "2"},
+		"typo":    {labels: map[string]string{LabelXelonCloudID: "cloud-2"}, want: ""},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := cloudIDFromLabels(tt.labels); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMaxVolumesPerNodeFromLabels(t *testing.T) {
+	tests := map[string]struct {
+		labels map[string]string
+		want   int
+	}{
+		"not set":  {labels: map[string]string{}, want: 0},
+		"valid":    {labels: map[string]string{LabelMaxVolumesPerNode: "8"}, want: 8},
+		"typo":     {labels: map[string]string{LabelMaxVolumesPerNode: "8x"}, want: 0},
+		"zero":     {labels: map[string]string{LabelMaxVolumesPerNode: "0"}, want: 0},
+		"negative": {labels: map[string]string{LabelMaxVolumesPerNode: "-1"}, want: 0},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := maxVolumesPerNodeFromLabels(tt.labels); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+// Provider covers the operations of the Xelon API used by the driver. Failed calls which received a
+// response from Xelon return a *xelon.ErrorResponse, so that callers can rely on its status code.
+type Provider interface {
+	GetCurrentTenant(ctx context.Context) (*xelon.Tenant, error)
+	ListClouds(ctx context.Context, tenantID string) ([]xelon.Cloud, error)
+	GetDevice(ctx context.Context, tenantID, localVMID string) (*xelon.DeviceRoot, error)
+
+	ListPersistentStorages(ctx context.Context, tenantID string) ([]xelon.PersistentStorage, error)
+	GetPersistentStorage(ctx context.Context, tenantID, localID string) (*xelon.PersistentStorage, error)
+	GetPersistentStorageByName(ctx context.Context, tenantID, name string) (*xelon.PersistentStorage, error)
+	CreatePersistentStorage(ctx context.Context, tenantID string, createRequest *xelon.PersistentStorageCreateRequest) (*xelon.APIResponse, error)
+	ExtendPersistentStorage(ctx context.Context, localID string, extendRequest *xelon.PersistentStorageExtendRequest) (*xelon.APIResponse, error)
+	DeletePersistentStorage(ctx context.Context, tenantID, localID string) error
+	AttachPersistentStorage(ctx context.Context, tenantID, localID string, attachRequest *xelon.PersistentStorageAttachDetachRequest) (*xelon.APIResponse, error)
+	DetachPersistentStorage(ctx context.Context, tenantID, localID string, detachRequest *xelon.PersistentStorageAttachDetachRequest) (*xelon.APIResponse, error)
+}
+
+// Compile-time check to ensure that xelonProvider satisfies the Provider interface.
+var _ Provider = &xelonProvider{}
+
+// xelonProvider implements Provider with the Xelon SDK.
+type xelonProvider struct {
+	client *xelon.Client
+}
+
+// NewXelonProvider creates a Provider backed by the Xelon API.
+func NewXelonProvider(token, clientID, baseURL, userAgent string, transportOpts TransportOptions) (Provider, error) {
+	client, err := NewXelonClient(token, clientID, baseURL, userAgent, transportOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &xelonProvider{client: client}, nil
+}
+
+func (p *xelonProvider) GetCurrentTenant(ctx context.Context) (*xelon.Tenant, error) {
+	tenant, resp, err := p.client.Tenants.GetCurrent(ctx)
+	return tenant, toErrorResponse(resp, err)
+}
+
+func (p *xelonProvider) ListClouds(ctx context.Context, tenantID string) ([]xelon.Cloud, error) {
+	clouds, resp, err := p.client.Clouds.List(ctx, tenantID)
+	return clouds, toErrorResponse(resp, err)
+}
+
+func (p *xelonProvider) GetDevice(ctx context.Context, tenantID, localVMID string) (*xelon.DeviceRoot, error) {
+	device, resp, err := p.client.Devices.Get(ctx, tenantID, localVMID)
+	return device, toErrorResponse(resp, err)
+}
+
+func (p *xelonProvider) ListPersistentStorages(ctx context.Context, tenantID string) ([]xelon.PersistentStorage, error) {
+	storages, resp, err := p.client.PersistentStorages.List(ctx, tenantID)
+	return storages, toErrorResponse(resp, err)
+}
+
+func (p *xelonProvider) GetPersistentStorage(ctx context.Context, tenantID, localID string) (*xelon.PersistentStorage, error) {
+	storage, resp, err := p.client.PersistentStorages.Get(ctx, tenantID, localID)
+	return storage, toErrorResponse(resp, err)
+}
+
+func (p *xelonProvider) GetPersistentStorageByName(ctx context.Context, tenantID, name string) (*xelon.PersistentStorage, error) {
+	storage, resp, err := p.client.PersistentStorages.GetByName(ctx, tenantID, name)
+	return storage, toErrorResponse(resp, err)
+}
+
+func (p *xelonProvider) CreatePersistentStorage(ctx context.Context, tenantID string, createRequest *xelon.PersistentStorageCreateRequest) (*xelon.APIResponse, error) {
+	apiResponse, resp, err := p.client.PersistentStorages.Create(ctx, tenantID, createRequest)
+	return apiResponse, toErrorResponse(resp, err)
+}
+
+func (p *xelonProvider) ExtendPersistentStorage(ctx context.Context, localID string, extendRequest *xelon.PersistentStorageExtendRequest) (*xelon.APIResponse, error) {
+	apiResponse, resp, err := p.client.PersistentStorages.Extend(ctx, localID, extendRequest)
+	return apiResponse, toErrorResponse(resp, err)
+}
+
+func (p *xelonProvider) DeletePersistentStorage(ctx context.Context, tenantID, localID string) error {
+	resp, err := p.client.PersistentStorages.Delete(ctx, tenantID, localID)
+	return toErrorResponse(resp, err)
+}
+
+func (p *xelonProvider) AttachPersistentStorage(ctx context.Context, tenantID, localID string, attachRequest *xelon.PersistentStorageAttachDetachRequest) (*xelon.APIResponse, error) {
+	apiResponse, resp, err := p.client.PersistentStorages.AttachToDevice(ctx, tenantID, localID, attachRequest)
+	return apiResponse, toErrorResponse(resp, err)
+}
+
+func (p *xelonProvider) DetachPersistentStorage(ctx context.Context, tenantID, localID string, detachRequest *xelon.PersistentStorageAttachDetachRequest) (*xelon.APIResponse, error) {
+	apiResponse, resp, err := p.client.PersistentStorages.DetachFromDevice(ctx, tenantID, localID, detachRequest)
+	return apiResponse, toErrorResponse(resp, err)
+}
+
+// toErrorResponse turns the error of a call which received an error status from Xelon into a
+// *xelon.ErrorResponse. The SDK returns the decoding error instead if the error body is not JSON
+// (e.g. an HTML page of a proxy), which would hide the status code from callers.
+func toErrorResponse(resp *xelon.Response, err error) error {
+	if err == nil {
+		return nil
+	}
+	var errorResponse *xelon.ErrorResponse
+	if errors.As(err, &errorResponse) {
+		return err
+	}
+	if resp != nil && resp.Response != nil && resp.Request != nil && resp.StatusCode >= http.StatusBadRequest {
+		return &xelon.ErrorResponse{Response: resp}
+	}
+	return err
+}
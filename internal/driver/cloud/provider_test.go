@@ -0,0 +1,54 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+func TestXelonProvider_errorResponse(t *testing.T) {
+	tests := map[string]struct {
+		body       string
+		statusCode int
+	}{
+		"JSON error body": {
+			body:       `{"code": 404, "error": "persistent storage not found"}`,
+			statusCode: http.StatusNotFound,
+		},
+		"empty error body": {
+			statusCode: http.StatusServiceUnavailable,
+		},
+		"HTML error body of a proxy": {
+			body:       "<html><body>502 Bad Gateway</body></html>",
+			statusCode: http.StatusBadGateway,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			provider, err := NewXelonProvider("token", "client", server.URL+"/", "test", TransportOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = provider.GetPersistentStorage(context.Background(), "tenant", "volume")
+			var errorResponse *xelon.ErrorResponse
+			if !errors.As(err, &errorResponse) {
+				t.Fatalf("expected *xelon.ErrorResponse, got %T (%v)", err, err)
+			}
+			if got := errorResponse.Response.StatusCode; got != tt.statusCode {
+				t.Errorf("expected status code %d, got %d", tt.statusCode, got)
+			}
+		})
+	}
+}
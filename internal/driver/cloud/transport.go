@@ -0,0 +1,221 @@
+package cloud
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+)
+
+// TransportOptions configures retries, rate limiting and concurrency of requests to the Xelon API.
+type TransportOptions struct {
+	// MaxRetries is the number of retries of a failed request, 0 disables retries.
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the exponential backoff between retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// RateLimit is the number of requests per second, 0 disables rate limiting.
+	RateLimit float64
+	// RateBurst is the number of requests which may exceed RateLimit at once.
+	RateBurst int
+
+	// MaxInFlight is the number of concurrent requests, 0 means unlimited.
+	MaxInFlight int
+}
+
+// retryTransport is a http.RoundTripper which retries failed requests with exponential backoff and
+// jitter, honours the Retry-After header, and applies a client-side rate limit as well as a limit
+// of concurrent requests. A single transport is shared by all calls of the driver.
+type retryTransport struct {
+	next http.RoundTripper
+	opts TransportOptions
+
+	limiter  *rate.Limiter
+	inFlight chan struct{}
+}
+
+func newRetryTransport(next http.RoundTripper, opts TransportOptions) *retryTransport {
+	t := &retryTransport{
+		next: next,
+		opts: opts,
+	}
+	if opts.RateLimit > 0 {
+		burst := opts.RateBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		t.limiter = rate.NewLimiter(rate.Limit(opts.RateLimit), burst)
+	}
+	if opts.MaxInFlight > 0 {
+		t.inFlight = make(chan struct{}, opts.MaxInFlight)
+	}
+	return t
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.roundTrip(req)
+		if attempt >= t.opts.MaxRetries || ctx.Err() != nil || !isRetryable(req, resp, err) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt, resp)
+		klog.V(2).InfoS("Retrying request to Xelon API",
+			"attempt", attempt+1,
+			"delay", delay,
+			"error", err,
+			"method", req.Method,
+			"status_code", statusCode(resp),
+			"url", req.URL.Path,
+		)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		// the body of the previous attempt was consumed, so the request must be rewound
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// roundTrip sends a single request after waiting for the rate limiter and a free in-flight slot.
+// The slot is released when the response body is closed.
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if t.limiter != nil {
+		if err := t.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.inFlight == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	select {
+	case t.inFlight <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-t.inFlight }
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// backoff returns the delay before the next attempt. It grows exponentially with equal jitter and
+// is bounded by MaxBackoff, but a longer Retry-After of the response takes precedence.
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	delay := t.opts.MinBackoff << attempt
+	if delay <= 0 || delay > t.opts.MaxBackoff {
+		delay = t.opts.MaxBackoff
+	}
+	if delay > 0 {
+		delay = delay/2 + rand.N(delay/2+1)
+	}
+
+	if retryAfter := parseRetryAfter(resp); retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// isRetryable returns true if the request can be sent again. Rate limited requests (429) were not
+// processed by Xelon and are always retried, other failures only for idempotent methods. Requests
+// with a body which can't be rewound are never retried, as the body was consumed by the attempt.
+func isRetryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	if err != nil {
+		return err != context.Canceled && err != context.DeadlineExceeded
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter returns the delay from the Retry-After header, which is either a number of
+// seconds or a HTTP date. It returns 0 if the header is not set or invalid.
+func parseRetryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+func statusCode(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// releasingBody releases the in-flight slot of a request once its response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	closed  bool
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if !b.closed {
+		b.closed = true
+		b.release()
+	}
+	return err
+}
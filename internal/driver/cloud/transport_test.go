@@ -0,0 +1,227 @@
+package cloud
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport_retries(t *testing.T) {
+	tests := map[string]struct {
+		method       string
+		unrewindable bool
+		statusCodes  []int
+		wantStatus   int
+		wantRequests int32
+	}{
+		"GET is retried on 503": {
+			method:       http.MethodGet,
+			statusCodes:  []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK},
+			wantStatus:   http.StatusOK,
+			wantRequests: 3,
+		},
+		"DELETE is retried on 504": {
+			method:       http.MethodDelete,
+			statusCodes:  []int{http.StatusGatewayTimeout, http.StatusNoContent},
+			wantStatus:   http.StatusNoContent,
+			wantRequests: 2,
+		},
+		"POST is not retried on 502": {
+			method:       http.MethodPost,
+			statusCodes:  []int{http.StatusBadGateway, http.StatusOK},
+			wantStatus:   http.StatusBadGateway,
+			wantRequests: 1,
+		},
+		"POST is retried on 429": {
+			method:       http.MethodPost,
+			statusCodes:  []int{http.StatusTooManyRequests, http.StatusCreated},
+			wantStatus:   http.StatusCreated,
+			wantRequests: 2,
+		},
+		"POST with unrewindable body is not retried on 429": {
+			method:       http.MethodPost,
+			unrewindable: true,
+			statusCodes:  []int{http.StatusTooManyRequests, http.StatusCreated},
+			wantStatus:   http.StatusTooManyRequests,
+			wantRequests: 1,
+		},
+		"GET is not retried on 500": {
+			method:       http.MethodGet,
+			statusCodes:  []int{http.StatusInternalServerError, http.StatusOK},
+			wantStatus:   http.StatusInternalServerError,
+			wantRequests: 1,
+		},
+		"retries are exhausted": {
+			method:       http.MethodGet,
+			statusCodes:  []int{503, 503, 503, 503, 503},
+			wantStatus:   http.StatusServiceUnavailable,
+			wantRequests: 4,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := requests.Add(1)
+				if body, _ := io.ReadAll(r.Body); r.Method == http.MethodPost && string(body) != "payload" {
+					t.Errorf("expected request body to be resent, got %q", body)
+				}
+				w.WriteHeader(tt.statusCodes[n-1])
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: newRetryTransport(http.DefaultTransport, TransportOptions{
+				MaxRetries: 3,
+				MinBackoff: time.Millisecond,
+				MaxBackoff: 5 * time.Millisecond,
+			})}
+			var body io.Reader = strings.NewReader("payload")
+			if tt.unrewindable {
+				// NewRequest can only rewind bodies of known types
+				body = io.NopCloser(body)
+			}
+			req, err := http.NewRequest(tt.method, server.URL, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("expected %d requests, got %d", tt.wantRequests, got)
+			}
+		})
+	}
+}
+
+func TestRetryTransport_retryAfter(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newRetryTransport(http.DefaultTransport, TransportOptions{
+		MaxRetries: 1,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+	})}
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected Retry-After of 1s to be honoured, retried after %s", elapsed)
+	}
+}
+
+func TestRetryTransport_maxInFlight(t *testing.T) {
+	const maxInFlight = 2
+
+	var inFlight, maxSeen atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxSeen.Load()
+			if n <= seen || maxSeen.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newRetryTransport(http.DefaultTransport, TransportOptions{
+		MaxInFlight: maxInFlight,
+	})}
+	done := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			_ = resp.Body.Close()
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+
+	if got := maxSeen.Load(); got > maxInFlight {
+		t.Errorf("expected at most %d requests in flight, got %d", maxInFlight, got)
+	}
+}
+
+func TestRetryTransport_rateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newRetryTransport(http.DefaultTransport, TransportOptions{
+		RateLimit: 20,
+		RateBurst: 1,
+	})}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	// the first request uses the burst, the other four wait 50ms each
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("expected requests to be rate limited, took %s", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := map[string]struct {
+		value string
+		want  time.Duration
+	}{
+		"empty":    {value: "", want: 0},
+		"seconds":  {value: "3", want: 3 * time.Second},
+		"negative": {value: "-1", want: 0},
+		"invalid":  {value: "soon", want: 0},
+		"past":     {value: "Mon, 02 Jan 2006 15:04:05 GMT", want: 0},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.value != "" {
+				resp.Header.Set("Retry-After", tt.value)
+			}
+			if got := parseRetryAfter(resp); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -2,13 +2,18 @@ package cloud
 
 import (
 	"errors"
+	"net/http"
+	"time"
 
 	"github.com/Xelon-AG/xelon-sdk-go/xelon"
 )
 
 type ClientOptions xelon.ClientOption
 
-func NewXelonClient(token, clientID, baseURL, userAgent string) (*xelon.Client, error) {
+// httpClientTimeout bounds a single call to the Xelon API, including all of its retries.
+const httpClientTimeout = 60 * time.Second
+
+func NewXelonClient(token, clientID, baseURL, userAgent string, transportOpts TransportOptions) (*xelon.Client, error) {
 	if token == "" {
 		return nil, errors.New("token must not be empty")
 	}
@@ -22,6 +27,10 @@ func NewXelonClient(token, clientID, baseURL, userAgent string) (*xelon.Client,
 	var opts []xelon.ClientOption
 	opts = append(opts, xelon.WithBaseURL(baseURL))
 	opts = append(opts, xelon.WithClientID(clientID))
+	opts = append(opts, xelon.WithHTTPClient(&http.Client{
+		Timeout:   httpClientTimeout,
+		Transport: newRetryTransport(http.DefaultTransport, transportOpts),
+	}))
 	opts = append(opts, xelon.WithUserAgent(userAgent))
 
 	client := xelon.NewClient(token, opts...)
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/http"
+	"maps"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -29,11 +31,21 @@ const (
 	minVolumeSizeInBytes     int64 = 5 * giB
 	defaultVolumeSizeInBytes int64 = 10 * giB
 
-	volumeStatusCheckInterval = 10 * time.Second
-	volumeStatusCheckTimeout  = 300 * time.Second
+	volumeStatusCheckTimeout     = 300 * time.Second
+	volumeAttachmentCheckTimeout = 60 * time.Second
 
 	xelonStorageUUID = DefaultDriverName + "/storage-uuid"
 	xelonStorageName = DefaultDriverName + "/storage-name"
+
+	// topologyCloudIDKey is the topology segment with the Xelon cloud of a node or volume
+	topologyCloudIDKey = "topology." + DefaultDriverName + "/cloud-id"
+)
+
+// polling intervals of the Xelon API, variables so that tests against a fake backend don't have
+// to wait for them
+var (
+	volumeStatusCheckInterval     = 10 * time.Second
+	volumeAttachmentCheckInterval = 3 * time.Second
 )
 
 var (
@@ -41,55 +53,102 @@ var (
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
 		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 	}
 
-	// Xelon currently only support a single volume to be attached to a single node
-	// in read/write mode. This corresponds to `accessModes.ReadWriteOnce` in a
-	// PVC resource on Kubernetes
-	supportedAccessMode = &csi.VolumeCapability_AccessMode{
-		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	// Xelon attaches persistent storages read/write, so a volume can only be written by a single
+	// node (`accessModes.ReadWriteOnce` and `ReadWriteOncePod` in Kubernetes). A persistent storage
+	// can be attached to several devices, which is only safe if none of them writes to it
+	// (`ReadOnlyMany`). The single node modes are enforced by the CO for the workloads on the node.
+	supportedAccessModes = []csi.VolumeCapability_AccessMode_Mode{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
 	}
 )
 
 type controllerService struct {
-	xelon *xelon.Client
-
-	cloudID  string
-	tenantID string
+	xelon       cloud.Provider
+	attachments *attachmentModes
+	locks       *keyLocks
+	operations  *operationTracker
+	volumes     *createdVolumes
+
+	maxVolumesPerDevice int
+
+	// cloudIDs are all clouds the controller creates volumes in, defaultCloudID is used if neither
+	// StorageClass parameters nor topology requirements select a cloud. Once created, persistent
+	// storages are addressed by tenant and local id only, so the cloud is not needed afterwards.
+	cloudIDs       map[string]struct{}
+	defaultCloudID string
+	tenantID       string
 }
 
 func newControllerService(ctx context.Context, opts *Options) (*controllerService, error) {
-	klog.V(2).InfoS("Initialize controller service")
-
-	xelonClient, err := cloud.NewXelonClient(opts.XelonToken, opts.XelonClientID, opts.XelonBaseURL, UserAgent())
+	xelonProvider, err := newXelonProvider(opts)
 	if err != nil {
 		return nil, err
 	}
+	return newControllerServiceWithProvider(ctx, opts, xelonProvider)
+}
+
+// newControllerServiceWithProvider creates the controller service on top of the given provider,
+// which allows to run the controller against a fake Xelon backend.
+func newControllerServiceWithProvider(ctx context.Context, opts *Options, xelonProvider cloud.Provider) (*controllerService, error) {
+	klog.V(2).InfoS("Initialize controller service")
 
 	controllerService := &controllerService{
-		xelon: xelonClient,
+		xelon:       xelonProvider,
+		attachments: newAttachmentModes(),
+		locks:       newKeyLocks(),
+		operations:  newOperationTracker(volumeStatusCheckTimeout),
+		volumes:     newCreatedVolumes(),
+
+		maxVolumesPerDevice: opts.MaxVolumesPerNode,
 	}
 
-	tenant, _, err := xelonClient.Tenants.GetCurrent(ctx)
+	tenant, err := xelonProvider.GetCurrentTenant(ctx)
 	if err != nil {
 		return nil, err
 	}
 	klog.V(5).InfoS("Fetched info about tenant", "tenant_id", tenant.TenantID)
 	controllerService.tenantID = tenant.TenantID
 
-	klog.V(5).InfoS("Verifying that tenant has an access to the cloud", "tenant_id", tenant.TenantID, "cloud_id", opts.XelonCloudID)
-	hvs, _, err := xelonClient.Clouds.List(ctx, tenant.TenantID)
+	if len(opts.XelonCloudIDs) == 0 {
+		return nil, errors.New("at least one cloud id must be specified")
+	}
+
+	klog.V(5).InfoS("Verifying that tenant has an access to the clouds", "tenant_id", tenant.TenantID, "cloud_ids", opts.XelonCloudIDs)
+	hvs, err := xelonProvider.ListClouds(ctx, tenant.TenantID)
 	if err != nil {
 		return nil, err
 	}
+	tenantCloudIDs := make(map[string]struct{}, len(hvs))
 	for _, hv := range hvs {
-		if strconv.Itoa(hv.ID) == opts.XelonCloudID {
-			controllerService.cloudID = opts.XelonCloudID
-			return controllerService, nil
+		tenantCloudIDs[strconv.Itoa(hv.ID)] = struct{}{}
+	}
+
+	controllerService.cloudIDs = make(map[string]struct{}, len(opts.XelonCloudIDs))
+	var inaccessibleCloudIDs []string
+	for _, cloudID := range opts.XelonCloudIDs {
+		if _, ok := tenantCloudIDs[cloudID]; !ok {
+			inaccessibleCloudIDs = append(inaccessibleCloudIDs, cloudID)
+			continue
 		}
+		controllerService.cloudIDs[cloudID] = struct{}{}
+	}
+	if len(inaccessibleCloudIDs) > 0 {
+		return nil, fmt.Errorf("tenant has no access to the specified clouds: %s", strings.Join(inaccessibleCloudIDs, ", "))
 	}
+	controllerService.defaultCloudID = opts.XelonCloudIDs[0]
 
-	return nil, errors.New("tenant has no access to the specified cloud")
+	return controllerService, nil
 }
 
 func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
@@ -99,90 +158,125 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if len(req.VolumeCapabilities) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "volume capabilities not provided")
 	}
+	for _, capability := range req.VolumeCapabilities {
+		if err := validateVolumeCapability(capability); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	switch {
+	case req.GetVolumeContentSource().GetSnapshot() != nil:
+		return nil, status.Error(codes.InvalidArgument, "creating volume from snapshot is not supported")
+	case req.GetVolumeContentSource().GetVolume() != nil:
+		return nil, status.Error(codes.InvalidArgument, "cloning volume is not supported")
+	}
+
+	parameters, err := parseCreateVolumeParameters(req.Parameters, req.VolumeCapabilities)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	size, err := extractStorage(req.CapacityRange)
 	if err != nil {
 		return nil, status.Errorf(codes.OutOfRange, "invalid capacity range: %v", err)
 	}
 
-	volumeName := req.Name
+	volumeName := parameters.NamePrefix + req.Name
+	if !d.locks.tryAcquire(volumeNameLockKey(volumeName)) {
+		return nil, status.Errorf(codes.Aborted, "an operation for volume %s is already in progress", volumeName)
+	}
+	defer d.locks.release(volumeNameLockKey(volumeName))
+
+	// a volume which was already created keeps its cloud, the persistent storage can't be moved
+	createdVolumeContext, created := d.volumes.get(volumeName)
+	var cloudID string
+	if created {
+		cloudID = createdVolumeContext[volumeContextCloudID]
+		if err = checkCreatedCloudID(cloudID, parameters.CloudID, req.AccessibilityRequirements); err != nil {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists: %v", volumeName, err)
+		}
+	} else {
+		cloudID, err = d.selectCloudID(parameters.CloudID, req.AccessibilityRequirements)
+		if err != nil {
+			return nil, err
+		}
+	}
+	accessibleTopology := []*csi.Topology{{
+		Segments: map[string]string{topologyCloudIDKey: cloudID},
+	}}
+
+	volumeContext := parameters.volumeContext(cloudID)
+	if created && !maps.Equal(createdVolumeContext, volumeContext) {
+		return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists with volume context %v, requested %v", volumeName, createdVolumeContext, volumeContext)
+	}
 
 	klog.V(2).InfoS("Creating new volume",
+		"cloud_id", cloudID,
 		"method", "CreateVolume",
 		"storage_size_gigabytes", size/giB,
+		"storage_type", parameters.StorageType,
 		"volume_capabilities", req.VolumeCapabilities,
 		"volume_name", volumeName,
 	)
 
-	klog.V(5).InfoS("Fetching persistent storage by name",
-		"method", "CreateVolume",
-		"tenant_id", d.tenantID,
-		"volume_name", volumeName,
-	)
-	storage, response, err := d.xelon.PersistentStorages.GetByName(ctx, d.tenantID, volumeName)
-	if err != nil && response != nil && response.StatusCode != http.StatusNotFound {
-		return nil, status.Error(codes.Internal, err.Error())
+	key := createOperationKey(volumeName)
+	if op := d.operations.get(key); op != nil {
+		klog.V(2).InfoS("Volume creation is already in progress",
+			"method", "CreateVolume",
+			"started_at", op.startedAt,
+			"volume_name", volumeName,
+		)
+		storage, err := d.awaitOperation(ctx, key, op)
+		if err != nil {
+			return nil, err
+		}
+		if err = checkCapacity(storage, req.CapacityRange); err != nil {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists: %v", volumeName, err)
+		}
+		return newCreateVolumeResponse(storage, volumeContext, accessibleTopology), nil
+	}
+
+	storage, err := d.findPersistentStorageByName(ctx, volumeName)
+	if err != nil {
+		return nil, err
 	}
 	if storage != nil {
-		if storage.UUID != "" && storage.Formatted == 1 {
+		if err = checkCapacity(storage, req.CapacityRange); err != nil {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s already exists: %v", volumeName, err)
+		}
+		// the volume context of a volume found after a restart of the controller can't be verified,
+		// the first call which finds it determines the volume context for the following calls
+		if !created {
+			d.volumes.set(volumeName, storage.LocalID, volumeContext)
+		}
+
+		if isPersistentStorageReady(storage) {
 			klog.V(2).InfoS("Volume already created",
-				"method", "CreateVolume",
-				"volume_name", volumeName,
-			)
-			return &csi.CreateVolumeResponse{
-				Volume: &csi.Volume{
-					VolumeId:      storage.LocalID,
-					CapacityBytes: int64(storage.Capacity * giB),
-				},
-			}, nil
-		} else {
-			klog.V(2).InfoS("Volume is still creating",
 				"method", "CreateVolume",
 				"volume_id", storage.LocalID,
 				"volume_name", volumeName,
 			)
-			return nil, status.Errorf(codes.AlreadyExists, "volume %s is creating", storage.LocalID)
+			return newCreateVolumeResponse(storage, volumeContext, accessibleTopology), nil
 		}
-	} else {
-		// fallback option to query all storages
-		storages, _, err := d.xelon.PersistentStorages.List(ctx, d.tenantID)
+
+		klog.V(2).InfoS("Volume is still creating, waiting for it to get ready",
+			"method", "CreateVolume",
+			"volume_id", storage.LocalID,
+			"volume_name", volumeName,
+		)
+		op := d.operations.start(key, d.waitForPersistentStorage(storage.LocalID, isPersistentStorageReady))
+		storage, err = d.awaitOperation(ctx, key, op)
 		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
-		}
-
-		for _, storage := range storages {
-			if storage.Name == volumeName {
-				// storage was created if 'uuid' is not empty and 'formatted' is 1, otherwise create is in progress state
-				if storage.UUID != "" && storage.Formatted == 1 {
-					klog.V(2).InfoS("Volume already created",
-						"method", "CreateVolume",
-						"volume_id", storage.LocalID,
-						"volume_name", volumeName,
-					)
-					return &csi.CreateVolumeResponse{
-						Volume: &csi.Volume{
-							VolumeId:      storage.LocalID,
-							CapacityBytes: int64(storage.Capacity * giB),
-						},
-					}, nil
-				} else {
-					klog.V(2).InfoS("Volume is still creating",
-						"method", "CreateVolume",
-						"volume_id", storage.LocalID,
-						"volume_name", volumeName,
-					)
-					return nil, status.Errorf(codes.AlreadyExists, "volume %s is creating", storage.LocalID)
-				}
-			}
+			return nil, err
 		}
+		return newCreateVolumeResponse(storage, volumeContext, accessibleTopology), nil
 	}
 
 	createRequest := &xelon.PersistentStorageCreateRequest{
 		PersistentStorage: &xelon.PersistentStorage{
 			Name: volumeName,
-			Type: 2,
+			Type: parameters.StorageType,
 		},
-		CloudID: d.cloudID,
+		CloudID: cloudID,
 		Size:    int(size / giB),
 	}
 	klog.V(5).InfoS("Creating persistent storage",
@@ -190,45 +284,34 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 		"payload", *createRequest,
 		"tenant_id", d.tenantID,
 	)
-	apiResponse, _, err := d.xelon.PersistentStorages.Create(ctx, d.tenantID, createRequest)
+	apiResponse, err := d.xelon.CreatePersistentStorage(ctx, d.tenantID, createRequest)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, xelonError(err, "could not create volume %s", volumeName)
 	}
 	klog.V(5).InfoS("Created persistent storage",
 		"method", "CreateVolume",
 		"response", *apiResponse,
 		"tenant_id", d.tenantID,
 	)
+	d.volumes.set(volumeName, apiResponse.PersistentStorage.LocalID, volumeContext)
 
 	klog.V(2).InfoS("Waiting for the volume to get ready",
 		"method", "CreateVolume",
 		"volume_id", apiResponse.PersistentStorage.LocalID,
 		"volume_name", volumeName,
 	)
-	if err = wait.PollUntilContextTimeout(ctx, volumeStatusCheckInterval, volumeStatusCheckTimeout, false, func(ctx context.Context) (bool, error) {
-		storage, _, err := d.xelon.PersistentStorages.Get(ctx, d.tenantID, apiResponse.PersistentStorage.LocalID)
-		if err != nil {
-			return false, status.Error(codes.Internal, err.Error())
-		}
-		if storage.UUID != "" && storage.Formatted == 1 {
-			return true, nil
-		}
-		return false, nil
-	}); err != nil {
-		return nil, status.Errorf(codes.Unknown, "volume is not ready")
+	op := d.operations.start(key, d.waitForPersistentStorage(apiResponse.PersistentStorage.LocalID, isPersistentStorageReady))
+	storage, err = d.awaitOperation(ctx, key, op)
+	if err != nil {
+		return nil, err
 	}
 
 	klog.V(2).InfoS("Created volume successfully",
 		"method", "CreateVolume",
-		"volume_id", apiResponse.PersistentStorage.LocalID,
+		"volume_id", storage.LocalID,
 		"volume_name", volumeName,
 	)
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      apiResponse.PersistentStorage.LocalID,
-			CapacityBytes: size,
-		},
-	}, nil
+	return newCreateVolumeResponse(storage, volumeContext, accessibleTopology), nil
 }
 
 func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
@@ -241,23 +324,28 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 		"volume_id", req.VolumeId,
 	)
 
+	if !d.locks.tryAcquire(volumeLockKey(req.VolumeId)) {
+		return nil, status.Errorf(codes.Aborted, "an operation for volume %s is already in progress", req.VolumeId)
+	}
+	defer d.locks.release(volumeLockKey(req.VolumeId))
+
 	klog.V(5).InfoS("Delete persistent storage",
 		"method", "DeleteVolume",
 		"tenant_id", d.tenantID,
 		"volume_id", req.VolumeId,
 	)
-	resp, err := d.xelon.PersistentStorages.Delete(ctx, d.tenantID, req.VolumeId)
-	if err != nil {
-		if resp != nil && resp.StatusCode == http.StatusNotFound {
+	if err := d.xelon.DeletePersistentStorage(ctx, d.tenantID, req.VolumeId); err != nil {
+		if isNotFound(err) {
 			klog.V(2).InfoS("Volume was not found, assuming it was deleted externally",
+				"error", err,
 				"method", "DeleteVolume",
-				"response", *resp,
 				"volume_id", req.VolumeId,
 			)
 			return &csi.DeleteVolumeResponse{}, nil
 		}
-		return nil, err
+		return nil, xelonError(err, "could not delete volume %s", req.VolumeId)
 	}
+	d.volumes.remove(req.VolumeId)
 
 	klog.V(2).InfoS("Deleted volume successfully",
 		"method", "DeleteVolume",
@@ -276,24 +364,34 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 	if req.VolumeCapability == nil {
 		return nil, status.Error(codes.InvalidArgument, "volume capability not provided")
 	}
+	if err := validateVolumeCapability(req.VolumeCapability); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	klog.V(2).InfoS("Publishing volume",
+		"access_mode", req.VolumeCapability.GetAccessMode().GetMode(),
 		"method", "ControllerPublishVolume",
 		"node_id", req.NodeId,
 		"volume_id", req.VolumeId,
 	)
 
+	lockKeys := []string{volumeLockKey(req.VolumeId), deviceLockKey(req.NodeId)}
+	if !d.locks.tryAcquire(lockKeys...) {
+		return nil, status.Errorf(codes.Aborted, "an operation for volume %s or device %s is already in progress", req.VolumeId, req.NodeId)
+	}
+	defer d.locks.release(lockKeys...)
+
 	klog.V(5).InfoS("Fetching persistent storage to ensure it exists",
 		"method", "ControllerPublishVolume",
 		"tenant_id", d.tenantID,
 		"volume_id", req.VolumeId,
 	)
-	storage, resp, err := d.xelon.PersistentStorages.Get(ctx, d.tenantID, req.VolumeId)
+	storage, err := d.xelon.GetPersistentStorage(ctx, d.tenantID, req.VolumeId)
 	if err != nil {
-		if resp != nil && resp.StatusCode == http.StatusNotFound {
+		if isNotFound(err) {
 			return nil, status.Errorf(codes.NotFound, "volume %q doesn't exist", req.VolumeId)
 		}
-		return nil, err
+		return nil, xelonError(err, "could not fetch volume %s", req.VolumeId)
 	}
 	klog.V(5).InfoS("Found persistent storage",
 		"method", "ControllerPublishVolume",
@@ -307,12 +405,12 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		"tenant_id", d.tenantID,
 		"node_id", req.NodeId,
 	)
-	device, resp, err := d.xelon.Devices.Get(ctx, d.tenantID, req.NodeId)
+	device, err := d.xelon.GetDevice(ctx, d.tenantID, req.NodeId)
 	if err != nil {
-		if resp != nil && resp.StatusCode == http.StatusNotFound {
+		if isNotFound(err) {
 			return nil, status.Errorf(codes.NotFound, "device %q doesn't exist", req.NodeId)
 		}
-		return nil, err
+		return nil, xelonError(err, "could not fetch device %s", req.NodeId)
 	}
 	if device == nil {
 		return nil, status.Errorf(codes.Unknown, "device %q must not be nil", req.NodeId)
@@ -324,6 +422,57 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		"tenant_id", d.tenantID,
 	)
 
+	publishContext := map[string]string{
+		xelonStorageUUID: storage.UUID,
+		xelonStorageName: storage.Name,
+	}
+
+	mode := req.VolumeCapability.GetAccessMode().GetMode()
+	if isAttachedToDevice(storage, req.NodeId) {
+		if attachedMode, ok := d.attachments.get(req.VolumeId, req.NodeId); ok && isReadOnlyAccessMode(attachedMode) != isReadOnlyAccessMode(mode) {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s is already published to device %s with access mode %s", req.VolumeId, req.NodeId, attachedMode)
+		}
+		// publishing an existing attachment again, e.g. after a restart of the controller,
+		// restores its access mode
+		d.attachments.set(req.VolumeId, req.NodeId, mode)
+
+		klog.V(2).InfoS("Volume is already published",
+			"method", "ControllerPublishVolume",
+			"node_id", req.NodeId,
+			"volume_id", req.VolumeId,
+		)
+		return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
+	}
+	// the persistent storage is attached read/write to every device, so further devices are only
+	// allowed if none of them writes to it
+	if nodeIDs := publishedNodeIDs(storage); len(nodeIDs) > 0 {
+		if !isMultiNodeAccessMode(mode) || !isReadOnlyAccessMode(mode) {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s is already published to other device(s): %s", req.VolumeId, strings.Join(nodeIDs, ", "))
+		}
+		if !d.attachments.readOnly(req.VolumeId, nodeIDs) {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %s is already published to other device(s) which may write to it: %s", req.VolumeId, strings.Join(nodeIDs, ", "))
+		}
+	}
+
+	klog.V(5).InfoS("Counting persistent storages attached to device",
+		"method", "ControllerPublishVolume",
+		"node_id", req.NodeId,
+		"tenant_id", d.tenantID,
+	)
+	storages, err := d.xelon.ListPersistentStorages(ctx, d.tenantID)
+	if err != nil {
+		return nil, xelonError(err, "could not list volumes")
+	}
+	attachedCount := 0
+	for i := range storages {
+		if isAttachedToDevice(&storages[i], req.NodeId) {
+			attachedCount++
+		}
+	}
+	if d.maxVolumesPerDevice > 0 && attachedCount >= d.maxVolumesPerDevice {
+		return nil, status.Errorf(codes.ResourceExhausted, "device %s has already %d of maximum %d volumes attached", req.NodeId, attachedCount, d.maxVolumesPerDevice)
+	}
+
 	attachRequest := &xelon.PersistentStorageAttachDetachRequest{ServerID: []string{req.NodeId}}
 	klog.V(5).InfoS("Attaching persistent storage to device",
 		"method", "ControllerPublishVolume",
@@ -331,9 +480,9 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		"tenant_id", d.tenantID,
 		"volume_id", storage.LocalID,
 	)
-	apiResponse, _, err := d.xelon.PersistentStorages.AttachToDevice(ctx, d.tenantID, storage.LocalID, attachRequest)
+	apiResponse, err := d.xelon.AttachPersistentStorage(ctx, d.tenantID, storage.LocalID, attachRequest)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, xelonError(err, "could not attach volume %s to device %s", req.VolumeId, req.NodeId)
 	}
 	klog.V(5).InfoS("Attached persistent storage",
 		"method", "ControllerPublishVolume",
@@ -342,18 +491,23 @@ func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.Controlle
 		"volume_id", storage.LocalID,
 	)
 
+	klog.V(2).InfoS("Waiting for the volume to get attached",
+		"method", "ControllerPublishVolume",
+		"node_id", req.NodeId,
+		"volume_id", req.VolumeId,
+	)
+	if err = d.waitForAttachment(ctx, req.VolumeId, req.NodeId, true); err != nil {
+		return nil, status.Errorf(codes.DeadlineExceeded, "volume %s is not attached to device %s yet: %v", req.VolumeId, req.NodeId, err)
+	}
+	d.attachments.set(req.VolumeId, req.NodeId, mode)
+
 	klog.V(2).InfoS("Published volume",
 		"method", "ControllerPublishVolume",
 		"node_id", req.NodeId,
-		"node_name", device.Device.LocalVMDetails.VMDisplayName,
+		"node_name", deviceDisplayName(device),
 		"volume_id", req.VolumeId,
 	)
-	return &csi.ControllerPublishVolumeResponse{
-		PublishContext: map[string]string{
-			xelonStorageUUID: storage.UUID,
-			xelonStorageName: storage.Name,
-		},
-	}, nil
+	return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
 }
 
 func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
@@ -370,17 +524,24 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 		"volume_id", req.VolumeId,
 	)
 
+	lockKeys := []string{volumeLockKey(req.VolumeId), deviceLockKey(req.NodeId)}
+	if !d.locks.tryAcquire(lockKeys...) {
+		return nil, status.Errorf(codes.Aborted, "an operation for volume %s or device %s is already in progress", req.VolumeId, req.NodeId)
+	}
+	defer d.locks.release(lockKeys...)
+
 	klog.V(5).InfoS("Fetching persistent storage to ensure it exists",
 		"method", "ControllerUnpublishVolume",
 		"tenant_id", d.tenantID,
 		"volume_id", req.VolumeId,
 	)
-	storage, resp, err := d.xelon.PersistentStorages.Get(ctx, d.tenantID, req.VolumeId)
+	storage, err := d.xelon.GetPersistentStorage(ctx, d.tenantID, req.VolumeId)
 	if err != nil {
-		if resp != nil && resp.StatusCode == http.StatusNotFound {
+		if isNotFound(err) {
+			d.attachments.remove(req.VolumeId, req.NodeId)
 			return &csi.ControllerUnpublishVolumeResponse{}, nil
 		}
-		return nil, err
+		return nil, xelonError(err, "could not fetch volume %s", req.VolumeId)
 	}
 	klog.V(5).InfoS("Found persistent storage",
 		"method", "ControllerUnpublishVolume",
@@ -389,27 +550,55 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 		"volume_id", req.VolumeId,
 	)
 
+	if !isAttachedToDevice(storage, req.NodeId) {
+		klog.V(2).InfoS("Volume is already unpublished",
+			"method", "ControllerUnpublishVolume",
+			"node_id", req.NodeId,
+			"volume_id", req.VolumeId,
+		)
+		d.attachments.remove(req.VolumeId, req.NodeId)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
 	klog.V(5).InfoS("Fetching device to ensure it exists",
 		"method", "ControllerUnpublishVolume",
 		"tenant_id", d.tenantID,
 		"node_id", req.NodeId,
 	)
-	device, resp, err := d.xelon.Devices.Get(ctx, d.tenantID, req.NodeId)
+	nodeName := ""
+	device, err := d.xelon.GetDevice(ctx, d.tenantID, req.NodeId)
 	if err != nil {
-		if resp != nil && resp.StatusCode == http.StatusNotFound {
-			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		if !isNotFound(err) {
+			return nil, xelonError(err, "could not fetch device %s", req.NodeId)
+		}
+		// the device was deleted (e.g. after a non-graceful node shutdown), but Xelon still
+		// reports the storage as attached to it, so the storage must be detached anyway. Xelon
+		// has no separate force detach, the regular detach is tried and its result checked.
+		klog.V(2).InfoS("Device doesn't exist anymore, detaching anyway",
+			"method", "ControllerUnpublishVolume",
+			"node_id", req.NodeId,
+			"volume_id", req.VolumeId,
+		)
+	} else {
+		if device == nil {
+			return nil, status.Errorf(codes.Unknown, "device %q must not be nil", req.NodeId)
+		}
+		klog.V(5).InfoS("Found device",
+			"method", "ControllerUnpublishVolume",
+			"node_id", req.NodeId,
+			"response", *device,
+			"tenant_id", d.tenantID,
+		)
+		nodeName = deviceDisplayName(device)
+		if device.Device != nil && !device.Device.PowerState {
+			klog.V(2).InfoS("Device is powered off, detaching anyway",
+				"method", "ControllerUnpublishVolume",
+				"node_id", req.NodeId,
+				"node_name", nodeName,
+				"volume_id", req.VolumeId,
+			)
 		}
-		return nil, err
-	}
-	if device == nil {
-		return nil, status.Errorf(codes.Unknown, "device %q must not be nil", req.NodeId)
 	}
-	klog.V(5).InfoS("Found device",
-		"method", "ControllerUnpublishVolume",
-		"node_id", req.NodeId,
-		"response", *device,
-		"tenant_id", d.tenantID,
-	)
 
 	detachRequest := &xelon.PersistentStorageAttachDetachRequest{ServerID: []string{req.NodeId}}
 	klog.V(5).InfoS("Detaching persistent storage from device",
@@ -418,24 +607,52 @@ func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.Control
 		"tenant_id", d.tenantID,
 		"volume_id", storage.LocalID,
 	)
-	apiResponse, resp, err := d.xelon.PersistentStorages.DetachFromDevice(ctx, d.tenantID, req.VolumeId, detachRequest)
+	apiResponse, err := d.xelon.DetachPersistentStorage(ctx, d.tenantID, req.VolumeId, detachRequest)
 	if err != nil {
-		if resp != nil && resp.StatusCode == http.StatusNotFound {
-			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		if !isNotFound(err) {
+			return nil, xelonError(err, "could not detach volume %s from device %s", req.VolumeId, req.NodeId)
 		}
-		return nil, err
+		// not found means that the storage or the device is already gone. If Xelon still lists
+		// the storage as attached to the gone device, waiting won't change that.
+		klog.V(2).InfoS("Persistent storage or device was not found while detaching",
+			"method", "ControllerUnpublishVolume",
+			"node_id", req.NodeId,
+			"volume_id", req.VolumeId,
+		)
+		storage, err = d.xelon.GetPersistentStorage(ctx, d.tenantID, req.VolumeId)
+		if err != nil {
+			if isNotFound(err) {
+				d.attachments.remove(req.VolumeId, req.NodeId)
+				return &csi.ControllerUnpublishVolumeResponse{}, nil
+			}
+			return nil, xelonError(err, "could not fetch volume %s", req.VolumeId)
+		}
+		if isAttachedToDevice(storage, req.NodeId) {
+			return nil, status.Errorf(codes.FailedPrecondition, "Xelon can't detach volume %s from device %s, which doesn't exist anymore, but still lists it as attached; remove the attachment in Xelon", req.VolumeId, req.NodeId)
+		}
+	} else {
+		klog.V(5).InfoS("Detached persistent storage",
+			"method", "ControllerUnpublishVolume",
+			"response", *apiResponse,
+			"tenant_id", d.tenantID,
+			"volume_id", storage.LocalID,
+		)
 	}
-	klog.V(5).InfoS("Detached persistent storage",
+
+	klog.V(2).InfoS("Waiting for the volume to get detached",
 		"method", "ControllerUnpublishVolume",
-		"response", *apiResponse,
-		"tenant_id", d.tenantID,
-		"volume_id", storage.LocalID,
+		"node_id", req.NodeId,
+		"volume_id", req.VolumeId,
 	)
+	if err = d.waitForAttachment(ctx, req.VolumeId, req.NodeId, false); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "volume %s is still being detached from device %s: %v", req.VolumeId, req.NodeId, err)
+	}
+	d.attachments.remove(req.VolumeId, req.NodeId)
 
 	klog.V(2).InfoS("Unpublished volume",
 		"method", "ControllerUnpublishVolume",
 		"node_id", req.NodeId,
-		"node_name", device.Device.LocalVMDetails.VMDisplayName,
+		"node_name", nodeName,
 		"volume_id", req.VolumeId,
 	)
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
@@ -453,7 +670,7 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 		"method", "ValidateVolumeCapabilities",
 		"volume_id", req.VolumeId,
 		"volume_capabilities", req.VolumeCapabilities,
-		"supported_capabilities", *supportedAccessMode,
+		"supported_access_modes", supportedAccessModes,
 	)
 
 	klog.V(5).InfoS("Fetching persistent storage to ensure it exists",
@@ -461,11 +678,12 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 		"tenant_id", d.tenantID,
 		"volume_id", req.VolumeId,
 	)
-	storage, resp, err := d.xelon.PersistentStorages.Get(ctx, d.tenantID, req.VolumeId)
+	storage, err := d.xelon.GetPersistentStorage(ctx, d.tenantID, req.VolumeId)
 	if err != nil {
-		if resp != nil && resp.StatusCode == http.StatusNotFound {
+		if isNotFound(err) {
 			return nil, status.Errorf(codes.NotFound, "volume %q doesn't exist", req.VolumeId)
 		}
+		return nil, xelonError(err, "could not fetch volume %s", req.VolumeId)
 	}
 	klog.V(5).InfoS("Found persistent storage",
 		"method", "ValidateVolumeCapabilities",
@@ -474,18 +692,113 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 		"volume_id", req.VolumeId,
 	)
 
+	for _, capability := range req.VolumeCapabilities {
+		if err = validateVolumeCapability(capability); err != nil {
+			klog.V(2).InfoS("Volume capability is not supported",
+				"error", err,
+				"method", "ValidateVolumeCapabilities",
+				"volume_capability", capability,
+				"volume_id", req.VolumeId,
+			)
+			return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+		}
+	}
+	// the capabilities are confirmed if CreateVolume accepts them together with the parameters,
+	// and if they don't conflict with the volume context the volume was created with
+	if _, err = parseCreateVolumeParameters(req.Parameters, req.VolumeCapabilities); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+	if err = checkVolumeContext(req.VolumeContext, req.VolumeCapabilities); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+
 	return &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
-			VolumeCapabilities: []*csi.VolumeCapability{{
-				AccessMode: supportedAccessMode,
-			}},
+			VolumeCapabilities: req.VolumeCapabilities,
+			VolumeContext:      req.VolumeContext,
+			Parameters:         req.Parameters,
 		},
 	}, nil
 }
 
-func (d *Driver) ListVolumes(_ context.Context, _ *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	klog.V(2).InfoS("Not yet implemented", "method", "ListVolumes")
-	return nil, status.Error(codes.Unimplemented, "ListVolumes is not yet implemented")
+func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	if req.MaxEntries < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "max entries must not be negative: %d", req.MaxEntries)
+	}
+
+	klog.V(2).InfoS("Listing volumes",
+		"method", "ListVolumes",
+		"max_entries", req.MaxEntries,
+		"starting_token", req.StartingToken,
+	)
+
+	klog.V(5).InfoS("Fetching persistent storages",
+		"method", "ListVolumes",
+		"tenant_id", d.tenantID,
+	)
+	storages, err := d.xelon.ListPersistentStorages(ctx, d.tenantID)
+	if err != nil {
+		return nil, xelonError(err, "could not list volumes")
+	}
+
+	// the tenant may have persistent storages which were not provisioned by the driver
+	storages = slices.DeleteFunc(storages, func(storage xelon.PersistentStorage) bool {
+		return !volumeNameRegexp.MatchString(storage.Name)
+	})
+
+	// sort storages by local id, so that the pagination is stable between calls
+	sort.Slice(storages, func(i, j int) bool {
+		return storages[i].LocalID < storages[j].LocalID
+	})
+
+	// starting token is the local id of the first storage on the requested page
+	start := 0
+	if req.StartingToken != "" {
+		start = -1
+		for i, storage := range storages {
+			if storage.LocalID == req.StartingToken {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return nil, status.Errorf(codes.Aborted, "invalid starting token %q", req.StartingToken)
+		}
+	}
+
+	end := len(storages)
+	if req.MaxEntries > 0 && start+int(req.MaxEntries) < end {
+		end = start + int(req.MaxEntries)
+	}
+
+	var entries []*csi.ListVolumesResponse_Entry
+	for _, storage := range storages[start:end] {
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				VolumeId:      storage.LocalID,
+				CapacityBytes: int64(storage.Capacity * giB),
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: publishedNodeIDs(&storage),
+				VolumeCondition:  volumeCondition(&storage),
+			},
+		})
+	}
+
+	var nextToken string
+	if end < len(storages) {
+		nextToken = storages[end].LocalID
+	}
+
+	klog.V(2).InfoS("Listed volumes",
+		"method", "ListVolumes",
+		"next_token", nextToken,
+		"volumes_count", len(entries),
+	)
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
 }
 
 func (d *Driver) GetCapacity(_ context.Context, _ *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
@@ -513,6 +826,8 @@ func (d *Driver) ControllerGetCapabilities(_ context.Context, req *csi.Controlle
 	return resp, nil
 }
 
+// CreateSnapshot, DeleteSnapshot and ListSnapshots are not implemented, because Xelon persistent
+// storage API does not provide snapshot endpoints yet.
 func (d *Driver) CreateSnapshot(_ context.Context, _ *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
 	klog.V(2).InfoS("Not yet implemented", "method", "CreateSnapshot")
 	return nil, status.Error(codes.Unimplemented, "CreateSnapshot is not yet implemented")
@@ -538,17 +853,49 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 		"volume_id", req.VolumeId,
 	)
 
+	if !d.locks.tryAcquire(volumeLockKey(req.VolumeId)) {
+		return nil, status.Errorf(codes.Aborted, "an operation for volume %s is already in progress", req.VolumeId)
+	}
+	defer d.locks.release(volumeLockKey(req.VolumeId))
+
+	resizeBytes, err := extractStorage(req.GetCapacityRange())
+	if err != nil {
+		return nil, status.Errorf(codes.OutOfRange, "invalid capacity range: %v", err)
+	}
+
+	// a retry may request a larger size than the expansion in progress, then the volume is
+	// expanded again once it has finished
+	key := expandOperationKey(req.VolumeId)
+	if op := d.operations.get(key); op != nil {
+		klog.V(2).InfoS("Volume expansion is already in progress",
+			"method", "ControllerExpandVolume",
+			"requested_volume_size_in_bytes", resizeBytes,
+			"started_at", op.startedAt,
+			"volume_id", req.VolumeId,
+		)
+		storage, err := d.awaitOperation(ctx, key, op)
+		if err != nil {
+			return nil, err
+		}
+		if capacityBytes := int64(storage.Capacity * giB); capacityBytes >= resizeBytes {
+			return &csi.ControllerExpandVolumeResponse{
+				CapacityBytes:         capacityBytes,
+				NodeExpansionRequired: true,
+			}, nil
+		}
+	}
+
 	klog.V(5).InfoS("Fetching persistent storage to ensure it exists",
 		"method", "ControllerExpandVolume",
 		"tenant_id", d.tenantID,
 		"volume_id", req.VolumeId,
 	)
-	storage, resp, err := d.xelon.PersistentStorages.Get(ctx, d.tenantID, req.VolumeId)
+	storage, err := d.xelon.GetPersistentStorage(ctx, d.tenantID, req.VolumeId)
 	if err != nil {
-		if resp != nil && resp.StatusCode == http.StatusNotFound {
+		if isNotFound(err) {
 			return nil, status.Errorf(codes.NotFound, "volume %q doesn't exist", req.VolumeId)
 		}
-		return nil, status.Errorf(codes.Internal, "could not fetch existing volume: %v", err)
+		return nil, xelonError(err, "could not fetch volume %s", req.VolumeId)
 	}
 	klog.V(5).InfoS("Found persistent storage",
 		"method", "ControllerExpandVolume",
@@ -557,11 +904,6 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 		"volume_id", req.VolumeId,
 	)
 
-	resizeBytes, err := extractStorage(req.GetCapacityRange())
-	if err != nil {
-		return nil, status.Errorf(codes.OutOfRange, "invalid capacity range: %v", err)
-	}
-
 	if resizeBytes <= int64(storage.Capacity*giB) {
 		klog.V(2).InfoS("Skip volume expanding because current volume size exceeds requested volume size",
 			"current_volume_size_in_bytes", int64(storage.Capacity*giB),
@@ -582,9 +924,9 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 		"tenant_id", d.tenantID,
 		"volume_id", req.VolumeId,
 	)
-	apiResponse, _, err := d.xelon.PersistentStorages.Extend(ctx, req.VolumeId, extendRequest)
+	apiResponse, err := d.xelon.ExtendPersistentStorage(ctx, req.VolumeId, extendRequest)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, xelonError(err, "could not extend volume %s", req.VolumeId)
 	}
 	klog.V(5).InfoS("Extended persistent storage",
 		"method", "ControllerExpandVolume",
@@ -597,17 +939,11 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 		"method", "ControllerExpandVolume",
 		"volume_id", req.VolumeId,
 	)
-	if err = wait.PollUntilContextTimeout(ctx, volumeStatusCheckInterval, volumeStatusCheckTimeout, false, func(ctx context.Context) (bool, error) {
-		storage, _, err := d.xelon.PersistentStorages.Get(ctx, d.tenantID, req.VolumeId)
-		if err != nil {
-			return false, status.Error(codes.Internal, err.Error())
-		}
-		if storage.UUID != "" && storage.Formatted == 1 {
-			return true, nil
-		}
-		return false, nil
-	}); err != nil {
-		return nil, status.Errorf(codes.Unknown, "volume is not ready")
+	op := d.operations.start(key, d.waitForPersistentStorage(req.VolumeId, func(storage *xelon.PersistentStorage) bool {
+		return isPersistentStorageReady(storage) && storage.Capacity >= extendRequest.Size
+	}))
+	if _, err = d.awaitOperation(ctx, key, op); err != nil {
+		return nil, err
 	}
 
 	klog.V(2).InfoS("Resized volume successfully",
@@ -622,9 +958,45 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 	}, nil
 }
 
-func (d *Driver) ControllerGetVolume(_ context.Context, _ *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
-	klog.V(2).InfoS("Not yet implemented", "method", "ControllerGetVolume")
-	return nil, status.Error(codes.Unimplemented, "ControllerGetVolume is not yet implemented")
+func (d *Driver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id not provided")
+	}
+
+	klog.V(2).InfoS("Getting volume",
+		"method", "ControllerGetVolume",
+		"volume_id", req.VolumeId,
+	)
+
+	klog.V(5).InfoS("Fetching persistent storage",
+		"method", "ControllerGetVolume",
+		"tenant_id", d.tenantID,
+		"volume_id", req.VolumeId,
+	)
+	storage, err := d.xelon.GetPersistentStorage(ctx, d.tenantID, req.VolumeId)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %q doesn't exist", req.VolumeId)
+		}
+		return nil, xelonError(err, "could not fetch volume %s", req.VolumeId)
+	}
+	klog.V(5).InfoS("Found persistent storage",
+		"method", "ControllerGetVolume",
+		"response", *storage,
+		"tenant_id", d.tenantID,
+		"volume_id", req.VolumeId,
+	)
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      storage.LocalID,
+			CapacityBytes: int64(storage.Capacity * giB),
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: publishedNodeIDs(storage),
+			VolumeCondition:  volumeCondition(storage),
+		},
+	}, nil
 }
 
 func (d *Driver) ControllerModifyVolume(_ context.Context, _ *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
@@ -632,6 +1004,216 @@ func (d *Driver) ControllerModifyVolume(_ context.Context, _ *csi.ControllerModi
 	return nil, status.Error(codes.Unimplemented, "ControllerModifyVolume is not yet implemented")
 }
 
+// findPersistentStorageByName returns the persistent storage with the given name, or nil if it
+// doesn't exist.
+func (d *Driver) findPersistentStorageByName(ctx context.Context, name string) (*xelon.PersistentStorage, error) {
+	klog.V(5).InfoS("Fetching persistent storage by name",
+		"method", "CreateVolume",
+		"tenant_id", d.tenantID,
+		"volume_name", name,
+	)
+	storage, err := d.xelon.GetPersistentStorageByName(ctx, d.tenantID, name)
+	if err != nil && !isNotFound(err) {
+		return nil, xelonError(err, "could not fetch volume %s", name)
+	}
+	if err == nil && storage != nil {
+		return storage, nil
+	}
+
+	// fallback option to query all storages
+	storages, err := d.xelon.ListPersistentStorages(ctx, d.tenantID)
+	if err != nil {
+		return nil, xelonError(err, "could not list volumes")
+	}
+	for i := range storages {
+		if storages[i].Name == name {
+			return &storages[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// waitForPersistentStorage returns an operation which polls the persistent storage with the
+// given local id until ready reports true. Failed requests are retried until the operation
+// times out.
+func (d *Driver) waitForPersistentStorage(localID string, ready func(*xelon.PersistentStorage) bool) func(context.Context) (*xelon.PersistentStorage, error) {
+	return func(ctx context.Context) (*xelon.PersistentStorage, error) {
+		var storage *xelon.PersistentStorage
+		err := wait.PollUntilContextCancel(ctx, volumeStatusCheckInterval, true, func(ctx context.Context) (bool, error) {
+			s, err := d.xelon.GetPersistentStorage(ctx, d.tenantID, localID)
+			if err != nil {
+				klog.ErrorS(err, "Failed to fetch persistent storage, retrying",
+					"tenant_id", d.tenantID,
+					"volume_id", localID,
+				)
+				return false, nil
+			}
+			storage = s
+			return ready(s), nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("persistent storage %s is not ready: %w", localID, err)
+		}
+		return storage, nil
+	}
+}
+
+// awaitOperation waits for the given operation until it completes or the context of the gRPC call
+// is done. In the latter case the operation continues in the background and a retryable error is
+// returned, so that the retried call picks up the result.
+func (d *Driver) awaitOperation(ctx context.Context, key string, op *operation) (*xelon.PersistentStorage, error) {
+	select {
+	case <-op.done:
+	case <-ctx.Done():
+		code := codes.Aborted
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			code = codes.DeadlineExceeded
+		}
+		return nil, status.Errorf(code, "operation %s is still in progress since %s", key, op.startedAt.Format(time.RFC3339))
+	}
+
+	d.operations.forget(key)
+	if op.err != nil {
+		return nil, status.Errorf(codes.Internal, "operation %s failed: %v", key, op.err)
+	}
+	return op.storage, nil
+}
+
+// waitForAttachment polls the persistent storage until Xelon reports it as attached to (attached is
+// true) or detached from (attached is false) the given device.
+func (d *Driver) waitForAttachment(ctx context.Context, volumeID, nodeID string, attached bool) error {
+	return wait.PollUntilContextTimeout(ctx, volumeAttachmentCheckInterval, volumeAttachmentCheckTimeout, true, func(ctx context.Context) (bool, error) {
+		storage, err := d.xelon.GetPersistentStorage(ctx, d.tenantID, volumeID)
+		if err != nil {
+			klog.ErrorS(err, "Failed to fetch persistent storage, retrying",
+				"tenant_id", d.tenantID,
+				"volume_id", volumeID,
+			)
+			return false, nil
+		}
+		return isAttachedToDevice(storage, nodeID) == attached, nil
+	})
+}
+
+// deviceDisplayName returns the display name of the device, or an empty string if Xelon didn't
+// return the details of the device.
+func deviceDisplayName(device *xelon.DeviceRoot) string {
+	if device == nil || device.Device == nil || device.Device.LocalVMDetails == nil {
+		return ""
+	}
+	return device.Device.LocalVMDetails.VMDisplayName
+}
+
+// isAttachedToDevice returns true if the persistent storage is attached to the given device.
+func isAttachedToDevice(storage *xelon.PersistentStorage, nodeID string) bool {
+	for _, server := range storage.AssignedServers {
+		if server.LocalVMID == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// isPersistentStorageReady returns true if Xelon has finished provisioning the persistent storage,
+// i.e. 'uuid' is not empty and 'formatted' is 1.
+func isPersistentStorageReady(storage *xelon.PersistentStorage) bool {
+	return storage.UUID != "" && storage.Formatted == 1
+}
+
+func newCreateVolumeResponse(storage *xelon.PersistentStorage, volumeContext map[string]string, accessibleTopology []*csi.Topology) *csi.CreateVolumeResponse {
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:           storage.LocalID,
+			CapacityBytes:      int64(storage.Capacity * giB),
+			VolumeContext:      volumeContext,
+			AccessibleTopology: accessibleTopology,
+		},
+	}
+}
+
+// selectCloudID returns the cloud in which a new volume is created. The cloud from StorageClass
+// parameters has priority, but it must satisfy the requisite topology if the CO provides it.
+// Otherwise, the first preferred and then the first requisite cloud managed by the controller is
+// taken, falling back to the default cloud of the controller.
+func (d *Driver) selectCloudID(parameterCloudID string, requirement *csi.TopologyRequirement) (string, error) {
+	var requisiteCloudIDs []string
+	for _, topology := range requirement.GetRequisite() {
+		if cloudID, ok := topology.GetSegments()[topologyCloudIDKey]; ok {
+			requisiteCloudIDs = append(requisiteCloudIDs, cloudID)
+		}
+	}
+	satisfiesRequisite := func(cloudID string) bool {
+		if len(requisiteCloudIDs) == 0 {
+			return true
+		}
+		for _, requisiteCloudID := range requisiteCloudIDs {
+			if requisiteCloudID == cloudID {
+				return true
+			}
+		}
+		return false
+	}
+
+	if parameterCloudID != "" {
+		if _, ok := d.cloudIDs[parameterCloudID]; !ok {
+			return "", status.Errorf(codes.InvalidArgument, "cloud %s is not managed by the controller", parameterCloudID)
+		}
+		if !satisfiesRequisite(parameterCloudID) {
+			return "", status.Errorf(codes.ResourceExhausted, "cloud %s from parameters does not satisfy requisite topology %v", parameterCloudID, requisiteCloudIDs)
+		}
+		return parameterCloudID, nil
+	}
+
+	for _, topology := range append(requirement.GetPreferred(), requirement.GetRequisite()...) {
+		cloudID, ok := topology.GetSegments()[topologyCloudIDKey]
+		if !ok {
+			continue
+		}
+		if _, ok := d.cloudIDs[cloudID]; ok && satisfiesRequisite(cloudID) {
+			return cloudID, nil
+		}
+	}
+
+	if !satisfiesRequisite(d.defaultCloudID) {
+		return "", status.Errorf(codes.ResourceExhausted, "none of the requisite clouds %v is managed by the controller", requisiteCloudIDs)
+	}
+	return d.defaultCloudID, nil
+}
+
+// checkCreatedCloudID returns an error if a repeated CreateVolume call asks for another cloud than
+// the volume was created in.
+func checkCreatedCloudID(createdCloudID, parameterCloudID string, requirement *csi.TopologyRequirement) error {
+	if parameterCloudID != "" && parameterCloudID != createdCloudID {
+		return fmt.Errorf("created in cloud %s, requested cloud %s", createdCloudID, parameterCloudID)
+	}
+	var requisiteCloudIDs []string
+	for _, topology := range requirement.GetRequisite() {
+		if cloudID, ok := topology.GetSegments()[topologyCloudIDKey]; ok {
+			if cloudID == createdCloudID {
+				return nil
+			}
+			requisiteCloudIDs = append(requisiteCloudIDs, cloudID)
+		}
+	}
+	if len(requisiteCloudIDs) > 0 {
+		return fmt.Errorf("created in cloud %s, which does not satisfy requisite topology %v", createdCloudID, requisiteCloudIDs)
+	}
+	return nil
+}
+
+// checkCapacity returns an error if the capacity of an existing persistent storage does not
+// satisfy the capacity range of a repeated CreateVolume call.
+func checkCapacity(storage *xelon.PersistentStorage, capacityRange *csi.CapacityRange) error {
+	capacity := int64(storage.Capacity * giB)
+	if requiredBytes := capacityRange.GetRequiredBytes(); requiredBytes > 0 && capacity < requiredBytes {
+		return fmt.Errorf("capacity %v is less than required %v", formatBytes(capacity), formatBytes(requiredBytes))
+	}
+	if limitBytes := capacityRange.GetLimitBytes(); limitBytes > 0 && capacity > limitBytes {
+		return fmt.Errorf("capacity %v exceeds limit %v", formatBytes(capacity), formatBytes(limitBytes))
+	}
+	return nil
+}
+
 // extractStorage extracts the storage size in bytes from the given capacity range. If the capacity
 // range is not satisfied it returns the default volume size. If the capacity range is below or
 // above supported sizes, it returns an error.
@@ -667,6 +1249,76 @@ func extractStorage(capacityRange *csi.CapacityRange) (int64, error) {
 	return minVolumeSizeInBytes, nil
 }
 
+// validateVolumeCapability returns an error if the driver does not support the access mode,
+// access type or fs type of the capability.
+func validateVolumeCapability(capability *csi.VolumeCapability) error {
+	mode := capability.GetAccessMode().GetMode()
+	if !slices.Contains(supportedAccessModes, mode) {
+		return fmt.Errorf("unsupported access mode %s", mode)
+	}
+
+	switch {
+	case capability.GetBlock() != nil:
+		// the node finds a device by the UUID of its filesystem, which a raw block workload
+		// overwrites, and Xelon exposes no stable identifier of the disk
+		return errors.New("block access type is not supported")
+	case capability.GetMount() != nil:
+		if fsType := capability.GetMount().GetFsType(); fsType != "" {
+			if _, err := parseFsType(fsType); err != nil {
+				return fmt.Errorf("invalid fs type %q: %w", fsType, err)
+			}
+		}
+	default:
+		return errors.New("access type must be block or mount")
+	}
+	return nil
+}
+
+// isReadOnlyAccessMode returns true if the volume must not be written with the access mode.
+func isReadOnlyAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+}
+
+// isMultiNodeAccessMode returns true if the volume may be published to several nodes with the
+// access mode.
+func isMultiNodeAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	return mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER ||
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+}
+
+// publishedNodeIDs returns the ids of all devices the given persistent storage is attached to.
+func publishedNodeIDs(storage *xelon.PersistentStorage) []string {
+	var nodeIDs []string
+	for _, server := range storage.AssignedServers {
+		nodeIDs = append(nodeIDs, server.LocalVMID)
+	}
+	return nodeIDs
+}
+
+// volumeCondition reports the given persistent storage as abnormal if Xelon has not finished
+// provisioning it, i.e. it has no filesystem uuid or is not formatted.
+func volumeCondition(storage *xelon.PersistentStorage) *csi.VolumeCondition {
+	switch {
+	case storage.UUID == "":
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("persistent storage %s has no filesystem uuid", storage.LocalID),
+		}
+	case storage.Formatted != 1:
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("persistent storage %s is not formatted", storage.LocalID),
+		}
+	default:
+		return &csi.VolumeCondition{
+			Abnormal: false,
+			Message:  "volume is healthy",
+		}
+	}
+}
+
 func formatBytes(inputBytes int64) string {
 	output := float64(inputBytes)
 	unit := ""
@@ -0,0 +1,920 @@
+package driver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud/fake"
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+// newFakeProvider returns a backend with the clouds 1 and 2, and a running device in each.
+func newFakeProvider() *fake.Provider {
+	p := fake.NewProvider()
+	p.AddCloud(1, "cloud-1")
+	p.AddCloud(2, "cloud-2")
+	p.AddDevice("dev-1", 1, true)
+	p.AddDevice("dev-2", 1, true)
+	p.AddDevice("dev-3", 2, true)
+	return p
+}
+
+// newTestController creates a controller managing the clouds 1 and 2 on top of the given backend
+// and shortens the polling intervals for the duration of the test.
+func newTestController(t *testing.T, p *fake.Provider) *Driver {
+	t.Helper()
+
+	statusCheckInterval, attachmentCheckInterval := volumeStatusCheckInterval, volumeAttachmentCheckInterval
+	volumeStatusCheckInterval, volumeAttachmentCheckInterval = 10*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() {
+		volumeStatusCheckInterval, volumeAttachmentCheckInterval = statusCheckInterval, attachmentCheckInterval
+	})
+
+	cs, err := newControllerServiceWithProvider(context.Background(), &Options{
+		MaxVolumesPerNode: 2,
+		XelonCloudIDs:     []string{"1", "2"},
+	}, p)
+	if err != nil {
+		t.Fatalf("failed to create controller service: %v", err)
+	}
+	return &Driver{controllerService: cs}
+}
+
+var singleNodeWriter = &csi.VolumeCapability_AccessMode{
+	Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+}
+
+func mountCapabilities() []*csi.VolumeCapability {
+	return []*csi.VolumeCapability{{
+		AccessMode: singleNodeWriter,
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}}
+}
+
+func assertCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("expected code %s, got %s (%v)", want, got, err)
+	}
+}
+
+func TestNewControllerServiceWithProvider(t *testing.T) {
+	tests := map[string]struct {
+		cloudIDs []string
+		failure  string
+		wantErr  bool
+	}{
+		"accessible clouds":   {cloudIDs: []string{"2", "1"}},
+		"no cloud":            {cloudIDs: nil, wantErr: true},
+		"inaccessible cloud":  {cloudIDs: []string{"1", "3"}, wantErr: true},
+		"tenant lookup fails": {cloudIDs: []string{"1"}, failure: fake.MethodGetCurrentTenant, wantErr: true},
+		"cloud lookup fails":  {cloudIDs: []string{"1"}, failure: fake.MethodListClouds, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := newFakeProvider()
+			if tt.failure != "" {
+				p.InjectFailure(tt.failure, http.StatusUnauthorized, 1)
+			}
+
+			cs, err := newControllerServiceWithProvider(context.Background(), &Options{XelonCloudIDs: tt.cloudIDs}, p)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cs.tenantID != fake.DefaultTenantID {
+				t.Errorf("expected tenant %s, got %s", fake.DefaultTenantID, cs.tenantID)
+			}
+			if cs.defaultCloudID != tt.cloudIDs[0] {
+				t.Errorf("expected default cloud %s, got %s", tt.cloudIDs[0], cs.defaultCloudID)
+			}
+		})
+	}
+}
+
+func TestController_CreateVolume(t *testing.T) {
+	p := newFakeProvider()
+	p.FormattingDuration = 50 * time.Millisecond
+	d := newTestController(t, p)
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 20 * giB},
+		VolumeCapabilities: mountCapabilities(),
+		Parameters: map[string]string{
+			parameterCloudID:     "2",
+			parameterNamePrefix:  "k8s-",
+			parameterStorageType: "1",
+		},
+	}
+	resp, err := d.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	volume := resp.Volume
+	if volume.CapacityBytes != 20*giB {
+		t.Errorf("expected capacity %d, got %d", 20*giB, volume.CapacityBytes)
+	}
+	if got := volume.AccessibleTopology[0].Segments[topologyCloudIDKey]; got != "2" {
+		t.Errorf("expected accessible topology of cloud 2, got %s", got)
+	}
+	storage, ok := p.PersistentStorage(volume.VolumeId)
+	if !ok {
+		t.Fatalf("expected persistent storage %s to exist", volume.VolumeId)
+	}
+	if storage.Name != "k8s-pvc-1" || storage.Type != 1 || !isPersistentStorageReady(&storage) {
+		t.Errorf("unexpected persistent storage %+v", storage)
+	}
+
+	// a retried call returns the same volume without creating another persistent storage
+	resp, err = d.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Volume.VolumeId != volume.VolumeId {
+		t.Errorf("expected volume %s, got %s", volume.VolumeId, resp.Volume.VolumeId)
+	}
+	if calls := p.Calls(fake.MethodCreatePersistentStorage); calls != 1 {
+		t.Errorf("expected 1 create call, got %d", calls)
+	}
+}
+
+func TestController_CreateVolume_fsType(t *testing.T) {
+	tests := map[string]struct {
+		capabilityFsType string
+		parameters       map[string]string
+		want             string
+	}{
+		"default": {
+			want: defaultFsType,
+		},
+		"capability": {
+			capabilityFsType: "EXT4",
+			want:             "ext4",
+		},
+		"parameter": {
+			parameters: map[string]string{parameterFsType: "Ext4"},
+			want:       "ext4",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := newTestController(t, newFakeProvider())
+
+			resp, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-1",
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessMode: singleNodeWriter,
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: tt.capabilityFsType}},
+				}},
+				Parameters: tt.parameters,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := resp.Volume.VolumeContext[volumeContextFsType]; got != tt.want {
+				t.Errorf("expected fs type %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestController_CreateVolume_inProgress(t *testing.T) {
+	p := newFakeProvider()
+	p.FormattingDuration = 300 * time.Millisecond
+	d := newTestController(t, p)
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		VolumeCapabilities: mountCapabilities(),
+	}
+
+	// the call times out while Xelon is still formatting the persistent storage
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := d.CreateVolume(ctx, req)
+	assertCode(t, err, codes.DeadlineExceeded)
+
+	// the retried call picks up the operation running in the background
+	resp, err := d.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Volume.CapacityBytes != defaultVolumeSizeInBytes {
+		t.Errorf("expected capacity %d, got %d", defaultVolumeSizeInBytes, resp.Volume.CapacityBytes)
+	}
+	if calls := p.Calls(fake.MethodCreatePersistentStorage); calls != 1 {
+		t.Errorf("expected 1 create call, got %d", calls)
+	}
+}
+
+func TestController_CreateVolume_alreadyExists(t *testing.T) {
+	d := newTestController(t, newFakeProvider())
+	topology := func(cloudIDs ...string) *csi.TopologyRequirement {
+		requirement := &csi.TopologyRequirement{}
+		for _, cloudID := range cloudIDs {
+			requirement.Requisite = append(requirement.Requisite, &csi.Topology{Segments: map[string]string{topologyCloudIDKey: cloudID}})
+		}
+		return requirement
+	}
+
+	created, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:                      "pvc-1",
+		VolumeCapabilities:        mountCapabilities(),
+		AccessibilityRequirements: topology("2"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a retry keeps the cloud the volume was created in, even if the topology prefers another one
+	resp, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:                      "pvc-1",
+		VolumeCapabilities:        mountCapabilities(),
+		AccessibilityRequirements: topology("1", "2"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Volume.VolumeId != created.Volume.VolumeId {
+		t.Errorf("expected volume %s, got %s", created.Volume.VolumeId, resp.Volume.VolumeId)
+	}
+	if got := resp.Volume.VolumeContext[volumeContextCloudID]; got != "2" {
+		t.Errorf("expected volume context of cloud 2, got %s", got)
+	}
+	if got := resp.Volume.AccessibleTopology[0].Segments[topologyCloudIDKey]; got != "2" {
+		t.Errorf("expected accessible topology of cloud 2, got %s", got)
+	}
+
+	tests := map[string]*csi.CreateVolumeRequest{
+		"other cloud parameter": {
+			Name:               "pvc-1",
+			VolumeCapabilities: mountCapabilities(),
+			Parameters:         map[string]string{parameterCloudID: "1"},
+		},
+		"other requisite topology": {
+			Name:                      "pvc-1",
+			VolumeCapabilities:        mountCapabilities(),
+			AccessibilityRequirements: topology("1"),
+		},
+		"other storage type": {
+			Name:               "pvc-1",
+			VolumeCapabilities: mountCapabilities(),
+			Parameters:         map[string]string{parameterStorageType: "1"},
+		},
+	}
+	for name, req := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := d.CreateVolume(context.Background(), req)
+			assertCode(t, err, codes.AlreadyExists)
+		})
+	}
+
+	// a deleted volume can be created again in another cloud
+	if _, err = d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: created.Volume.VolumeId}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err = d.CreateVolume(context.Background(), tests["other cloud parameter"])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := resp.Volume.VolumeContext[volumeContextCloudID]; got != "1" {
+		t.Errorf("expected volume context of cloud 1, got %s", got)
+	}
+}
+
+func TestController_CreateVolume_errors(t *testing.T) {
+	tests := map[string]struct {
+		req        *csi.CreateVolumeRequest
+		failure    string
+		statusCode int
+		want       codes.Code
+	}{
+		"missing name": {
+			req:  &csi.CreateVolumeRequest{VolumeCapabilities: mountCapabilities()},
+			want: codes.InvalidArgument,
+		},
+		"unknown parameter": {
+			req: &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				VolumeCapabilities: mountCapabilities(),
+				Parameters:         map[string]string{"unknown": "value"},
+			},
+			want: codes.InvalidArgument,
+		},
+		"unsupported access mode": {
+			req: &csi.CreateVolumeRequest{
+				Name: "pvc-1",
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER},
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				}},
+			},
+			want: codes.InvalidArgument,
+		},
+		"block access type": {
+			req: &csi.CreateVolumeRequest{
+				Name: "pvc-1",
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessMode: singleNodeWriter,
+					AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				}},
+			},
+			want: codes.InvalidArgument,
+		},
+		"unsupported capability fs type": {
+			req: &csi.CreateVolumeRequest{
+				Name: "pvc-1",
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessMode: singleNodeWriter,
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ntfs"}},
+				}},
+			},
+			want: codes.InvalidArgument,
+		},
+		"filesystem other than the preformatted ext4": {
+			req: &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				VolumeCapabilities: mountCapabilities(),
+				Parameters:         map[string]string{parameterFsType: "xfs"},
+			},
+			want: codes.InvalidArgument,
+		},
+		"invalid format option": {
+			req: &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				VolumeCapabilities: mountCapabilities(),
+				Parameters:         map[string]string{parameterReservedBlocksPercentage: "51"},
+			},
+			want: codes.InvalidArgument,
+		},
+		"unsupported storage type": {
+			req: &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				VolumeCapabilities: mountCapabilities(),
+				Parameters:         map[string]string{parameterStorageType: "7"},
+			},
+			want: codes.InvalidArgument,
+		},
+		"mkfs option": {
+			req: &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				VolumeCapabilities: mountCapabilities(),
+				Parameters:         map[string]string{"blockSize": "4096"},
+			},
+			want: codes.InvalidArgument,
+		},
+		"unmanaged cloud": {
+			req: &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				VolumeCapabilities: mountCapabilities(),
+				Parameters:         map[string]string{parameterCloudID: "3"},
+			},
+			want: codes.InvalidArgument,
+		},
+		"rate limited": {
+			req:        &csi.CreateVolumeRequest{Name: "pvc-1", VolumeCapabilities: mountCapabilities()},
+			failure:    fake.MethodCreatePersistentStorage,
+			statusCode: http.StatusTooManyRequests,
+			want:       codes.ResourceExhausted,
+		},
+		"lookup unavailable": {
+			req:        &csi.CreateVolumeRequest{Name: "pvc-1", VolumeCapabilities: mountCapabilities()},
+			failure:    fake.MethodGetPersistentStorageByName,
+			statusCode: http.StatusServiceUnavailable,
+			want:       codes.Unavailable,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := newFakeProvider()
+			d := newTestController(t, p)
+			if tt.failure != "" {
+				p.InjectFailure(tt.failure, tt.statusCode, 1)
+			}
+
+			_, err := d.CreateVolume(context.Background(), tt.req)
+			assertCode(t, err, tt.want)
+		})
+	}
+}
+
+func TestController_DeleteVolume(t *testing.T) {
+	p := newFakeProvider()
+	d := newTestController(t, p)
+	storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+
+	if _, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: storage.LocalID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := p.PersistentStorage(storage.LocalID); ok {
+		t.Fatalf("expected persistent storage %s to be deleted", storage.LocalID)
+	}
+
+	// deleting a volume which doesn't exist succeeds
+	if _, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: storage.LocalID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p.InjectFailure(fake.MethodDeletePersistentStorage, http.StatusServiceUnavailable, 1)
+	_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: storage.LocalID})
+	assertCode(t, err, codes.Unavailable)
+}
+
+func TestController_PublishUnpublishVolume(t *testing.T) {
+	p := newFakeProvider()
+	d := newTestController(t, p)
+	storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+
+	publishReq := &csi.ControllerPublishVolumeRequest{
+		VolumeId:         storage.LocalID,
+		NodeId:           "dev-1",
+		VolumeCapability: mountCapabilities()[0],
+	}
+	resp, err := d.ControllerPublishVolume(context.Background(), publishReq)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := resp.PublishContext[xelonStorageUUID]; got != storage.UUID {
+		t.Errorf("expected storage uuid %s in publish context, got %s", storage.UUID, got)
+	}
+
+	// publishing again to the same device is idempotent
+	if _, err = d.ControllerPublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := p.Calls(fake.MethodAttachPersistentStorage); calls != 1 {
+		t.Errorf("expected 1 attach call, got %d", calls)
+	}
+
+	// a single node writer volume can't be published to another device
+	_, err = d.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         storage.LocalID,
+		NodeId:           "dev-2",
+		VolumeCapability: mountCapabilities()[0],
+	})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	unpublishReq := &csi.ControllerUnpublishVolumeRequest{VolumeId: storage.LocalID, NodeId: "dev-1"}
+	if _, err = d.ControllerUnpublishVolume(context.Background(), unpublishReq); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, _ := p.PersistentStorage(storage.LocalID); len(current.AssignedServers) != 0 {
+		t.Errorf("expected persistent storage to be detached, got %+v", current.AssignedServers)
+	}
+
+	// unpublishing again is idempotent
+	if _, err = d.ControllerUnpublishVolume(context.Background(), unpublishReq); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := p.Calls(fake.MethodDetachPersistentStorage); calls != 1 {
+		t.Errorf("expected 1 detach call, got %d", calls)
+	}
+}
+
+func TestController_PublishVolume_multiNodeReader(t *testing.T) {
+	p := newFakeProvider()
+	d := newTestController(t, p)
+	storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+	capability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY},
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}
+
+	for _, nodeID := range []string{"dev-1", "dev-2"} {
+		if _, err := d.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         storage.LocalID,
+			NodeId:           nodeID,
+			VolumeCapability: capability,
+			Readonly:         true,
+		}); err != nil {
+			t.Fatalf("unexpected error publishing to %s: %v", nodeID, err)
+		}
+	}
+	if current, _ := p.PersistentStorage(storage.LocalID); len(current.AssignedServers) != 2 {
+		t.Errorf("expected persistent storage to be attached to 2 devices, got %+v", current.AssignedServers)
+	}
+}
+
+func TestController_PublishVolume_multiNodeReaderWithWriter(t *testing.T) {
+	p := newFakeProvider()
+	d := newTestController(t, p)
+	storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+	reader := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY},
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}
+	publish := func(d *Driver, nodeID string, capability *csi.VolumeCapability) error {
+		_, err := d.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         storage.LocalID,
+			NodeId:           nodeID,
+			VolumeCapability: capability,
+		})
+		return err
+	}
+
+	if err := publish(d, "dev-1", mountCapabilities()[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the writer on dev-1 would mount the volume read/write while dev-2 reads it
+	assertCode(t, publish(d, "dev-2", reader), codes.FailedPrecondition)
+	// dev-1 is already published with a writer mode
+	assertCode(t, publish(d, "dev-1", reader), codes.AlreadyExists)
+
+	if _, err := d.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: storage.LocalID, NodeId: "dev-1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := publish(d, "dev-1", reader); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// after a restart, the mode of the attachment to dev-1 is unknown until it is published again
+	restarted := newTestController(t, p)
+	assertCode(t, publish(restarted, "dev-2", reader), codes.FailedPrecondition)
+	if err := publish(restarted, "dev-1", reader); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := publish(restarted, "dev-2", reader); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestController_PublishVolume_errors(t *testing.T) {
+	tests := map[string]struct {
+		volumeID   string
+		nodeID     string
+		capability *csi.VolumeCapability
+		prepare    func(p *fake.Provider)
+		want       codes.Code
+	}{
+		"unsupported access mode": {
+			nodeID: "dev-1",
+			capability: &csi.VolumeCapability{
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			},
+			want: codes.InvalidArgument,
+		},
+		"volume not found": {
+			volumeID: "missing",
+			nodeID:   "dev-1",
+			want:     codes.NotFound,
+		},
+		"device not found": {
+			nodeID: "missing",
+			want:   codes.NotFound,
+		},
+		"attachment limit reached": {
+			nodeID: "dev-1",
+			prepare: func(p *fake.Provider) {
+				for _, name := range []string{"pvc-2", "pvc-3"} {
+					s := p.AddPersistentStorage(xelon.PersistentStorage{Name: name, Capacity: 10})
+					_, _ = p.AttachPersistentStorage(context.Background(), p.TenantID(), s.LocalID, &xelon.PersistentStorageAttachDetachRequest{ServerID: []string{"dev-1"}})
+				}
+			},
+			want: codes.ResourceExhausted,
+		},
+		"attach forbidden": {
+			nodeID: "dev-1",
+			prepare: func(p *fake.Provider) {
+				p.InjectFailure(fake.MethodAttachPersistentStorage, http.StatusForbidden, 1)
+			},
+			want: codes.PermissionDenied,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := newFakeProvider()
+			d := newTestController(t, p)
+			storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+			if tt.volumeID == "" {
+				tt.volumeID = storage.LocalID
+			}
+			if tt.capability == nil {
+				tt.capability = mountCapabilities()[0]
+			}
+			if tt.prepare != nil {
+				tt.prepare(p)
+			}
+
+			_, err := d.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+				VolumeId:         tt.volumeID,
+				NodeId:           tt.nodeID,
+				VolumeCapability: tt.capability,
+			})
+			assertCode(t, err, tt.want)
+		})
+	}
+}
+
+func TestController_UnpublishVolume_goneDevice(t *testing.T) {
+	p := newFakeProvider()
+	d := newTestController(t, p)
+	storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+
+	_, err := d.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         storage.LocalID,
+		NodeId:           "dev-1",
+		VolumeCapability: mountCapabilities()[0],
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.RemoveDevice("dev-1")
+
+	if _, err = d.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: storage.LocalID, NodeId: "dev-1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, _ := p.PersistentStorage(storage.LocalID); len(current.AssignedServers) != 0 {
+		t.Errorf("expected persistent storage to be detached, got %+v", current.AssignedServers)
+	}
+}
+
+func TestController_UnpublishVolume_stuckAttachment(t *testing.T) {
+	p := newFakeProvider()
+	d := newTestController(t, p)
+	storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+	_, _ = p.AttachPersistentStorage(context.Background(), p.TenantID(), storage.LocalID, &xelon.PersistentStorageAttachDetachRequest{ServerID: []string{"dev-1"}})
+	p.RemoveDevice("dev-1")
+
+	// Xelon rejects detaching from the gone device, but keeps listing the attachment
+	p.InjectFailure(fake.MethodDetachPersistentStorage, http.StatusNotFound, -1)
+	_, err := d.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: storage.LocalID, NodeId: "dev-1"})
+	assertCode(t, err, codes.FailedPrecondition)
+}
+
+func TestDeviceDisplayName(t *testing.T) {
+	tests := map[string]struct {
+		device *xelon.DeviceRoot
+		want   string
+	}{
+		"no device":  {device: nil, want: ""},
+		"no details": {device: &xelon.DeviceRoot{}, want: ""},
+		"no local vm details": {
+			device: &xelon.DeviceRoot{Device: &xelon.Device{PowerState: true}},
+			want:   "",
+		},
+		"display name": {
+			device: &xelon.DeviceRoot{Device: &xelon.Device{LocalVMDetails: &xelon.DeviceLocalVMDetails{VMDisplayName: "node-1"}}},
+			want:   "node-1",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := deviceDisplayName(tt.device); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestController_ValidateVolumeCapabilities(t *testing.T) {
+	accessMode := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability_AccessMode {
+		return &csi.VolumeCapability_AccessMode{Mode: mode}
+	}
+	mount := &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}
+
+	tests := map[string]struct {
+		capabilities  []*csi.VolumeCapability
+		parameters    map[string]string
+		volumeContext map[string]string
+		confirmed     bool
+	}{
+		"single node writer": {
+			capabilities: mountCapabilities(),
+			confirmed:    true,
+		},
+		"matching volume context": {
+			capabilities: []*csi.VolumeCapability{{
+				AccessMode: singleNodeWriter,
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			}},
+			parameters:    map[string]string{parameterReservedBlocksPercentage: "5"},
+			volumeContext: map[string]string{volumeContextFsType: "ext4", volumeContextReservedBlocksPercentage: "5"},
+			confirmed:     true,
+		},
+		"block": {
+			capabilities: []*csi.VolumeCapability{{
+				AccessMode: singleNodeWriter,
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			}},
+		},
+		"readers": {
+			capabilities: []*csi.VolumeCapability{
+				{AccessMode: accessMode(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY), AccessType: mount},
+				{AccessMode: accessMode(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY), AccessType: mount},
+			},
+			confirmed: true,
+		},
+		"multi node writer": {
+			capabilities: []*csi.VolumeCapability{
+				{AccessMode: singleNodeWriter, AccessType: mount},
+				{AccessMode: accessMode(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER), AccessType: mount},
+			},
+		},
+		"unsupported fs type": {
+			capabilities: []*csi.VolumeCapability{{
+				AccessMode: singleNodeWriter,
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ntfs"}},
+			}},
+		},
+		"missing access type": {
+			capabilities: []*csi.VolumeCapability{{AccessMode: singleNodeWriter}},
+		},
+		"invalid parameters": {
+			capabilities: mountCapabilities(),
+			parameters:   map[string]string{parameterFsType: "ntfs"},
+		},
+		"parameters rejected by CreateVolume": {
+			capabilities: mountCapabilities(),
+			parameters:   map[string]string{"blockSize": "4096"},
+		},
+		"fs type conflicting with volume context": {
+			capabilities: []*csi.VolumeCapability{{
+				AccessMode: singleNodeWriter,
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			}},
+			volumeContext: map[string]string{volumeContextFsType: "xfs"},
+		},
+		"invalid format option in volume context": {
+			capabilities:  mountCapabilities(),
+			volumeContext: map[string]string{volumeContextReservedBlocksPercentage: "5 -O ^has_journal"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := newFakeProvider()
+			d := newTestController(t, p)
+			storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+
+			resp, err := d.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           storage.LocalID,
+				VolumeCapabilities: tt.capabilities,
+				VolumeContext:      tt.volumeContext,
+				Parameters:         tt.parameters,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if confirmed := resp.Confirmed != nil; confirmed != tt.confirmed {
+				t.Fatalf("expected confirmed %t, got %+v", tt.confirmed, resp)
+			}
+			if tt.confirmed && len(resp.Confirmed.VolumeCapabilities) != len(tt.capabilities) {
+				t.Errorf("expected requested capabilities to be confirmed, got %+v", resp.Confirmed.VolumeCapabilities)
+			}
+			if !tt.confirmed && resp.Message == "" {
+				t.Errorf("expected message for unsupported capabilities")
+			}
+		})
+	}
+
+	d := newTestController(t, newFakeProvider())
+	_, err := d.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           "missing",
+		VolumeCapabilities: mountCapabilities(),
+	})
+	assertCode(t, err, codes.NotFound)
+}
+
+func TestController_ListVolumes(t *testing.T) {
+	p := newFakeProvider()
+	d := newTestController(t, p)
+	var volumeIDs []string
+	for _, name := range []string{"pvc-1", "team-a-pvc-2", "pvc-3"} {
+		volumeIDs = append(volumeIDs, p.AddPersistentStorage(xelon.PersistentStorage{Name: name, Capacity: 10}).LocalID)
+	}
+	// persistent storages which were not provisioned by the driver are not listed
+	p.AddPersistentStorage(xelon.PersistentStorage{Name: "backup", Capacity: 10})
+	p.AddPersistentStorage(xelon.PersistentStorage{Name: "team-a-data", Capacity: 10})
+
+	var listed []string
+	token := ""
+	for {
+		resp, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 2, StartingToken: token})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, entry := range resp.Entries {
+			listed = append(listed, entry.Volume.VolumeId)
+		}
+		if token = resp.NextToken; token == "" {
+			break
+		}
+	}
+	if len(listed) != len(volumeIDs) {
+		t.Fatalf("expected volumes %v, got %v", volumeIDs, listed)
+	}
+	for i := range listed {
+		if listed[i] != volumeIDs[i] {
+			t.Fatalf("expected volumes %v, got %v", volumeIDs, listed)
+		}
+	}
+
+	_, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{StartingToken: "unknown"})
+	assertCode(t, err, codes.Aborted)
+}
+
+func TestController_ExpandVolume(t *testing.T) {
+	p := newFakeProvider()
+	p.FormattingDuration = 50 * time.Millisecond
+	d := newTestController(t, p)
+	storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+
+	resp, err := d.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      storage.LocalID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 15 * giB},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.CapacityBytes != 15*giB || !resp.NodeExpansionRequired {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if current, _ := p.PersistentStorage(storage.LocalID); current.Capacity != 15 {
+		t.Errorf("expected capacity 15, got %d", current.Capacity)
+	}
+
+	// a smaller size doesn't extend the persistent storage again
+	if _, err = d.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      storage.LocalID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 12 * giB},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := p.Calls(fake.MethodExtendPersistentStorage); calls != 1 {
+		t.Errorf("expected 1 extend call, got %d", calls)
+	}
+}
+
+func TestController_ExpandVolume_largerRetry(t *testing.T) {
+	p := newFakeProvider()
+	p.FormattingDuration = 300 * time.Millisecond
+	d := newTestController(t, p)
+	storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+
+	// the call times out while Xelon is still extending the persistent storage
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := d.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      storage.LocalID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 15 * giB},
+	})
+	assertCode(t, err, codes.DeadlineExceeded)
+
+	// a retry with a larger size must not succeed with the size of the running expansion
+	resp, err := d.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      storage.LocalID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 20 * giB},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.CapacityBytes != 20*giB {
+		t.Errorf("expected capacity %d, got %d", 20*giB, resp.CapacityBytes)
+	}
+	if current, _ := p.PersistentStorage(storage.LocalID); current.Capacity != 20 {
+		t.Errorf("expected capacity 20, got %d", current.Capacity)
+	}
+	if calls := p.Calls(fake.MethodExtendPersistentStorage); calls != 2 {
+		t.Errorf("expected 2 extend calls, got %d", calls)
+	}
+}
+
+func TestController_GetVolume(t *testing.T) {
+	p := newFakeProvider()
+	d := newTestController(t, p)
+	storage := p.AddPersistentStorage(xelon.PersistentStorage{Name: "pvc-1", Capacity: 10})
+	_, _ = p.AttachPersistentStorage(context.Background(), p.TenantID(), storage.LocalID, &xelon.PersistentStorageAttachDetachRequest{ServerID: []string{"dev-3"}})
+
+	resp, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: storage.LocalID})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nodeIDs := resp.Status.PublishedNodeIds; len(nodeIDs) != 1 || nodeIDs[0] != "dev-3" {
+		t.Errorf("expected published node dev-3, got %v", nodeIDs)
+	}
+	if resp.Status.VolumeCondition.Abnormal {
+		t.Errorf("expected normal volume condition, got %+v", resp.Status.VolumeCondition)
+	}
+
+	_, err = d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "missing"})
+	assertCode(t, err, codes.NotFound)
+}
@@ -9,11 +9,14 @@ import (
 	"os/signal"
 	"path"
 	"path/filepath"
+	"sync"
 	"syscall"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc"
 	"k8s.io/klog/v2"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud"
 )
 
 // Mode represents the mode in which the CSI driver started
@@ -22,6 +25,15 @@ type Mode string
 const (
 	DefaultDriverName = "csi.xelon.ch"
 
+	// DefaultMaxVolumesPerNode is the number of persistent storages which can be attached to a
+	// single Xelon device, i.e. the free slots of its SCSI controller.
+	DefaultMaxVolumesPerNode = 15
+
+	// LabelMaxVolumesPerNode overrides the maximum number of volumes of a single node.
+	LabelMaxVolumesPerNode = cloud.LabelMaxVolumesPerNode
+	// LabelXelonCloudID sets the Xelon cloud of a node.
+	LabelXelonCloudID = cloud.LabelXelonCloudID
+
 	ControllerMode Mode = "controller"
 	NodeMode       Mode = "node"
 	AllMode        Mode = "all"
@@ -41,6 +53,9 @@ var (
 type Driver struct {
 	*controllerService
 	*nodeService
+
+	// mu guards srv, which is set by Run and stopped by Stop
+	mu  sync.Mutex
 	srv *grpc.Server
 
 	endpoint string
@@ -88,6 +103,19 @@ func NewDriver(ctx context.Context, opts *Options) (*Driver, error) {
 	return d, nil
 }
 
+// newXelonProvider creates a provider for the Xelon API which retries failed requests and applies
+// the configured rate and concurrency limits.
+func newXelonProvider(opts *Options) (cloud.Provider, error) {
+	return cloud.NewXelonProvider(opts.XelonToken, opts.XelonClientID, opts.XelonBaseURL, UserAgent(), cloud.TransportOptions{
+		MaxRetries:  opts.XelonAPIMaxRetries,
+		MinBackoff:  opts.XelonAPIRetryMinBackoff,
+		MaxBackoff:  opts.XelonAPIRetryMaxBackoff,
+		RateLimit:   opts.XelonAPIRateLimit,
+		RateBurst:   opts.XelonAPIRateBurst,
+		MaxInFlight: opts.XelonAPIMaxInFlight,
+	})
+}
+
 func (d *Driver) Run() error {
 	endpointURL, err := url.Parse(d.endpoint)
 	if err != nil {
@@ -119,31 +147,50 @@ func (d *Driver) Run() error {
 		}
 		return resp, err
 	}
-	d.srv = grpc.NewServer(grpc.UnaryInterceptor(logErrorHandler))
+	srv := grpc.NewServer(grpc.UnaryInterceptor(logErrorHandler))
 
-	csi.RegisterIdentityServer(d.srv, d)
+	csi.RegisterIdentityServer(srv, d)
 
 	switch d.mode {
 	case ControllerMode:
-		csi.RegisterControllerServer(d.srv, d)
+		csi.RegisterControllerServer(srv, d)
 	case NodeMode:
-		csi.RegisterNodeServer(d.srv, d)
+		csi.RegisterNodeServer(srv, d)
 	case AllMode:
-		csi.RegisterControllerServer(d.srv, d)
-		csi.RegisterNodeServer(d.srv, d)
+		csi.RegisterControllerServer(srv, d)
+		csi.RegisterNodeServer(srv, d)
 	default:
 		return fmt.Errorf("unknown mode for driver: %s", d.mode)
 	}
 
+	d.mu.Lock()
+	d.srv = srv
+	d.mu.Unlock()
+
 	// graceful shutdown
 	gracefulStop := make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGINT, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(gracefulStop)
+		close(gracefulStop)
+	}()
 	go func() {
-		<-gracefulStop
-		klog.InfoS("Stopping GRPC server gracefully", "endpoint", d.endpoint)
-		d.srv.GracefulStop()
+		if _, ok := <-gracefulStop; ok {
+			d.Stop()
+		}
 	}()
 
 	klog.InfoS("Starting GRPC server", "endpoint", d.endpoint)
-	return d.srv.Serve(grpcListener)
+	return srv.Serve(grpcListener)
+}
+
+// Stop stops the GRPC server gracefully, so that Run returns once pending calls have finished.
+func (d *Driver) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.srv != nil {
+		klog.InfoS("Stopping GRPC server gracefully", "endpoint", d.endpoint)
+		d.srv.GracefulStop()
+	}
 }
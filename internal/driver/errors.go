@@ -0,0 +1,92 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+// xelonError translates an error returned by the Xelon API into a gRPC status error, so that the
+// CO can tell retryable failures from permanent ones. The message is prefixed with the formatted
+// description of the failed call.
+func xelonError(err error, format string, args ...any) error {
+	return status.Errorf(xelonErrorCode(err), "%s: %v", fmt.Sprintf(format, args...), err)
+}
+
+// xelonErrorCode returns the gRPC code for an error returned by the Xelon API.
+func xelonErrorCode(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code()
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, xelon.ErrEmptyArgument), errors.Is(err, xelon.ErrEmptyPayloadNotAllowed):
+		return codes.InvalidArgument
+	}
+
+	if statusCode := httpStatusCode(err); statusCode != 0 {
+		return httpStatusToCode(statusCode)
+	}
+
+	// network errors, including timeouts of the HTTP client, mean that Xelon could not be reached
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return codes.Unavailable
+	}
+
+	return codes.Internal
+}
+
+// isNotFound returns true if the Xelon API responded with 404 Not Found.
+func isNotFound(err error) bool {
+	return httpStatusCode(err) == http.StatusNotFound
+}
+
+// httpStatusCode returns the HTTP status code of a failed call to the Xelon API, or 0 if the call
+// didn't receive a response.
+func httpStatusCode(err error) int {
+	var errorResponse *xelon.ErrorResponse
+	if errors.As(err, &errorResponse) && errorResponse.Response != nil && errorResponse.Response.Response != nil {
+		return errorResponse.Response.StatusCode
+	}
+	return 0
+}
+
+func httpStatusToCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	if statusCode >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
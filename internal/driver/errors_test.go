@@ -0,0 +1,175 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud"
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+func newXelonErrorResponse(statusCode int) error {
+	req, _ := http.NewRequest(http.MethodGet, "https://vdc.xelon.ch/api/service/tenant/persistent-storages", nil)
+	return &xelon.ErrorResponse{
+		Response: &xelon.Response{Response: &http.Response{StatusCode: statusCode, Request: req, Header: http.Header{}}},
+	}
+}
+
+func TestXelonErrorCode(t *testing.T) {
+	tests := map[string]struct {
+		statusCode int
+		err        error
+		want       codes.Code
+	}{
+		"no error":                   {err: nil, want: codes.OK},
+		"400 bad request":            {statusCode: http.StatusBadRequest, want: codes.InvalidArgument},
+		"401 unauthorized":           {statusCode: http.StatusUnauthorized, want: codes.Unauthenticated},
+		"403 forbidden":              {statusCode: http.StatusForbidden, want: codes.PermissionDenied},
+		"404 not found":              {statusCode: http.StatusNotFound, want: codes.NotFound},
+		"409 conflict":               {statusCode: http.StatusConflict, want: codes.Aborted},
+		"422 unprocessable entity":   {statusCode: http.StatusUnprocessableEntity, want: codes.InvalidArgument},
+		"429 too many requests":      {statusCode: http.StatusTooManyRequests, want: codes.ResourceExhausted},
+		"500 internal server error":  {statusCode: http.StatusInternalServerError, want: codes.Internal},
+		"501 not implemented":        {statusCode: http.StatusNotImplemented, want: codes.Unimplemented},
+		"502 bad gateway":            {statusCode: http.StatusBadGateway, want: codes.Unavailable},
+		"503 service unavailable":    {statusCode: http.StatusServiceUnavailable, want: codes.Unavailable},
+		"504 gateway timeout":        {statusCode: http.StatusGatewayTimeout, want: codes.Unavailable},
+		"418 unexpected client code": {statusCode: http.StatusTeapot, want: codes.Unknown},
+		"context canceled":           {err: context.Canceled, want: codes.Canceled},
+		"context deadline exceeded":  {err: fmt.Errorf("request failed: %w", context.DeadlineExceeded), want: codes.DeadlineExceeded},
+		"empty argument":             {err: xelon.ErrEmptyArgument, want: codes.InvalidArgument},
+		"empty payload":              {err: xelon.ErrEmptyPayloadNotAllowed, want: codes.InvalidArgument},
+		"network timeout":            {err: &url.Error{Op: "Get", URL: "https://vdc.xelon.ch", Err: &net.DNSError{IsTimeout: true}}, want: codes.Unavailable},
+		"connection refused":         {err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: codes.Unavailable},
+		"status error is kept":       {err: status.Error(codes.FailedPrecondition, "precondition"), want: codes.FailedPrecondition},
+		"unknown error":              {err: errors.New("unexpected"), want: codes.Internal},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tt.err
+			if tt.statusCode != 0 {
+				err = newXelonErrorResponse(tt.statusCode)
+			}
+
+			if got := xelonErrorCode(err); got != tt.want {
+				t.Errorf("expected code %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"error response 404": {
+			err:  newXelonErrorResponse(http.StatusNotFound),
+			want: true,
+		},
+		"wrapped error response 404": {
+			err:  fmt.Errorf("wrapped: %w", newXelonErrorResponse(http.StatusNotFound)),
+			want: true,
+		},
+		"error response 500": {
+			err:  newXelonErrorResponse(http.StatusInternalServerError),
+			want: false,
+		},
+		"no error": {
+			err:  nil,
+			want: false,
+		},
+		"network error": {
+			err:  &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			want: false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := isNotFound(tt.err); got != tt.want {
+				t.Errorf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestController_xelonErrors(t *testing.T) {
+	tests := map[string]struct {
+		statusCode int
+		want       codes.Code
+	}{
+		"not found":           {statusCode: http.StatusNotFound, want: codes.NotFound},
+		"unauthorized":        {statusCode: http.StatusUnauthorized, want: codes.Unauthenticated},
+		"server error":        {statusCode: http.StatusInternalServerError, want: codes.Internal},
+		"service unavailable": {statusCode: http.StatusServiceUnavailable, want: codes.Unavailable},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer server.Close()
+
+			xelonProvider, err := cloud.NewXelonProvider("token", "client", server.URL+"/", "test", cloud.TransportOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := &Driver{
+				controllerService: &controllerService{
+					xelon:      xelonProvider,
+					locks:      newKeyLocks(),
+					operations: newOperationTracker(volumeStatusCheckTimeout),
+					tenantID:   "tenant",
+				},
+			}
+			volumeCapabilities := []*csi.VolumeCapability{{
+				AccessMode: singleNodeWriter,
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			}}
+
+			calls := map[string]func() error{
+				"ValidateVolumeCapabilities": func() error {
+					_, err := d.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+						VolumeId:           "vol-1",
+						VolumeCapabilities: volumeCapabilities,
+					})
+					return err
+				},
+				"ControllerGetVolume": func() error {
+					_, err := d.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "vol-1"})
+					return err
+				},
+				"ControllerExpandVolume": func() error {
+					_, err := d.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{VolumeId: "vol-1"})
+					return err
+				},
+				"ControllerPublishVolume": func() error {
+					_, err := d.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+						VolumeId:         "vol-1",
+						NodeId:           "dev-1",
+						VolumeCapability: volumeCapabilities[0],
+					})
+					return err
+				},
+			}
+			for method, call := range calls {
+				if got := status.Code(call()); got != tt.want {
+					t.Errorf("%s: expected code %s, got %s", method, tt.want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,689 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/mount-utils"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud"
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud/fake"
+)
+
+// testNodeID is the device on which the node service of the integration harness runs.
+const testNodeID = "dev-1"
+
+// integrationHarness runs the driver in all mode on a temporary unix socket, with the fake Xelon
+// backend, a fake mounter and fake block devices, and talks to it over gRPC like a CO does. The
+// integration tests cover the driver's own behaviour, TestSanity runs the spec conformance tests
+// of csi-test/pkg/sanity against the same harness.
+type integrationHarness struct {
+	provider *fake.Provider
+	mounter  *mount.FakeMounter
+	exec     *fakeExec
+	dir      string
+
+	identity   csi.IdentityClient
+	controller csi.ControllerClient
+	node       csi.NodeClient
+}
+
+func newIntegrationHarness(t *testing.T) *integrationHarness {
+	t.Helper()
+
+	p := newFakeProvider()
+	d := newTestController(t, p)
+
+	h := &integrationHarness{
+		provider: p,
+		mounter:  mount.NewFakeMounter(nil),
+		exec:     newFakeExec(),
+		dir:      t.TempDir(),
+	}
+	ns, err := newNodeServiceWithDependencies(context.Background(),
+		&Options{MaxVolumesPerNode: DefaultMaxVolumesPerNode, RescanOnResize: true},
+		&cloud.Metadata{LocalVMID: testNodeID, Name: "node-1"},
+		p,
+		&mount.SafeFormatAndMount{Interface: h.mounter, Exec: h.exec},
+		&fakeBlockDevices{provider: p, nodeID: testNodeID},
+	)
+	if err != nil {
+		t.Fatalf("failed to create node service: %v", err)
+	}
+	d.nodeService = ns
+	d.mode = AllMode
+	socket := filepath.Join(h.dir, "csi.sock")
+	d.endpoint = "unix://" + socket
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- d.Run()
+	}()
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial driver: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		d.Stop()
+		if err := <-runErr; err != nil {
+			t.Errorf("driver stopped with error: %v", err)
+		}
+	})
+
+	h.identity = csi.NewIdentityClient(conn)
+	h.controller = csi.NewControllerClient(conn)
+	h.node = csi.NewNodeClient(conn)
+
+	// wait until the driver serves requests
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := h.identity.Probe(context.Background(), &csi.ProbeRequest{})
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("driver did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return h
+}
+
+func TestIntegration_Identity(t *testing.T) {
+	h := newIntegrationHarness(t)
+	ctx := context.Background()
+
+	info, err := h.identity.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{})
+	if err != nil {
+		t.Fatalf("GetPluginInfo: unexpected error: %v", err)
+	}
+	if info.Name != DefaultDriverName {
+		t.Errorf("GetPluginInfo: expected name %s, got %s", DefaultDriverName, info.Name)
+	}
+
+	capabilities, err := h.identity.GetPluginCapabilities(ctx, &csi.GetPluginCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("GetPluginCapabilities: unexpected error: %v", err)
+	}
+	hasControllerService := false
+	for _, capability := range capabilities.Capabilities {
+		if capability.GetService().GetType() == csi.PluginCapability_Service_CONTROLLER_SERVICE {
+			hasControllerService = true
+		}
+	}
+	if !hasControllerService {
+		t.Errorf("GetPluginCapabilities: expected CONTROLLER_SERVICE, got %v", capabilities.Capabilities)
+	}
+}
+
+func TestIntegration_invalidRequests(t *testing.T) {
+	h := newIntegrationHarness(t)
+	ctx := context.Background()
+	capability := mountCapabilities()[0]
+	blockCapability := &csi.VolumeCapability{
+		AccessMode: singleNodeWriter,
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+	}
+	stagingPath := filepath.Join(h.dir, "staging")
+	targetPath := filepath.Join(h.dir, "target")
+
+	tests := map[string]struct {
+		call func() error
+		want codes.Code
+	}{
+		"CreateVolume without name": {
+			call: func() error {
+				_, err := h.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{VolumeCapabilities: mountCapabilities()})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"CreateVolume without capabilities": {
+			call: func() error {
+				_, err := h.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "pvc-1"})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"DeleteVolume without volume id": {
+			call: func() error {
+				_, err := h.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"DeleteVolume of nonexistent volume": {
+			call: func() error {
+				_, err := h.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "missing"})
+				return err
+			},
+			want: codes.OK,
+		},
+		"ValidateVolumeCapabilities without capabilities": {
+			call: func() error {
+				_, err := h.controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: "missing"})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"ValidateVolumeCapabilities of nonexistent volume": {
+			call: func() error {
+				_, err := h.controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+					VolumeId:           "missing",
+					VolumeCapabilities: mountCapabilities(),
+				})
+				return err
+			},
+			want: codes.NotFound,
+		},
+		"ControllerPublishVolume without volume id": {
+			call: func() error {
+				_, err := h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{NodeId: testNodeID, VolumeCapability: capability})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"ControllerPublishVolume without node id": {
+			call: func() error {
+				_, err := h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{VolumeId: "missing", VolumeCapability: capability})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"ControllerPublishVolume without capability": {
+			call: func() error {
+				_, err := h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{VolumeId: "missing", NodeId: testNodeID})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"ControllerPublishVolume of nonexistent volume": {
+			call: func() error {
+				_, err := h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{VolumeId: "missing", NodeId: testNodeID, VolumeCapability: capability})
+				return err
+			},
+			want: codes.NotFound,
+		},
+		"ControllerUnpublishVolume of nonexistent volume": {
+			call: func() error {
+				_, err := h.controller.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: "missing", NodeId: testNodeID})
+				return err
+			},
+			want: codes.OK,
+		},
+		"ListVolumes with invalid starting token": {
+			call: func() error {
+				_, err := h.controller.ListVolumes(ctx, &csi.ListVolumesRequest{StartingToken: "invalid"})
+				return err
+			},
+			want: codes.Aborted,
+		},
+		"ControllerGetVolume of nonexistent volume": {
+			call: func() error {
+				_, err := h.controller.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: "missing"})
+				return err
+			},
+			want: codes.NotFound,
+		},
+		"ControllerExpandVolume without volume id": {
+			call: func() error {
+				_, err := h.controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodeStageVolume without staging target path": {
+			call: func() error {
+				_, err := h.node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{VolumeId: "missing", VolumeCapability: capability})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodeStageVolume without capability": {
+			call: func() error {
+				_, err := h.node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{VolumeId: "missing", StagingTargetPath: stagingPath})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodeStageVolume with block access type": {
+			call: func() error {
+				_, err := h.node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+					VolumeId:          "missing",
+					PublishContext:    map[string]string{xelonStorageName: "pvc-1", xelonStorageUUID: "uuid"},
+					StagingTargetPath: stagingPath,
+					VolumeCapability:  blockCapability,
+				})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodeStageVolume with invalid format option": {
+			call: func() error {
+				_, err := h.node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+					VolumeId:          "missing",
+					PublishContext:    map[string]string{xelonStorageName: "pvc-1", xelonStorageUUID: "uuid"},
+					StagingTargetPath: stagingPath,
+					VolumeCapability:  capability,
+					VolumeContext:     map[string]string{volumeContextReservedBlocksPercentage: "5 -O ^has_journal"},
+				})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodeUnstageVolume without volume id": {
+			call: func() error {
+				_, err := h.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{StagingTargetPath: stagingPath})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodePublishVolume without target path": {
+			call: func() error {
+				_, err := h.node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{VolumeId: "missing", StagingTargetPath: stagingPath, VolumeCapability: capability})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodePublishVolume with block access type": {
+			call: func() error {
+				_, err := h.node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+					VolumeId:          "missing",
+					StagingTargetPath: stagingPath,
+					TargetPath:        targetPath,
+					VolumeCapability:  blockCapability,
+				})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodeUnpublishVolume without target path": {
+			call: func() error {
+				_, err := h.node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "missing"})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodeUnpublishVolume of nonexistent target path": {
+			call: func() error {
+				_, err := h.node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "missing", TargetPath: targetPath})
+				return err
+			},
+			want: codes.OK,
+		},
+		"NodeGetVolumeStats without volume path": {
+			call: func() error {
+				_, err := h.node.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "missing"})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodeGetVolumeStats of nonexistent volume path": {
+			call: func() error {
+				_, err := h.node.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: "missing", VolumePath: targetPath})
+				return err
+			},
+			want: codes.NotFound,
+		},
+		"NodeExpandVolume without volume path": {
+			call: func() error {
+				_, err := h.node.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{VolumeId: "missing"})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		"NodeExpandVolume without volume id": {
+			call: func() error {
+				_, err := h.node.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{VolumePath: targetPath})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assertCode(t, tt.call(), tt.want)
+		})
+	}
+}
+
+func TestIntegration_Node(t *testing.T) {
+	h := newIntegrationHarness(t)
+	ctx := context.Background()
+
+	info, err := h.node.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetInfo: unexpected error: %v", err)
+	}
+	if info.NodeId != testNodeID || info.MaxVolumesPerNode != DefaultMaxVolumesPerNode {
+		t.Errorf("NodeGetInfo: unexpected response %+v", info)
+	}
+	if got := info.AccessibleTopology.GetSegments()[topologyCloudIDKey]; got != "1" {
+		t.Errorf("NodeGetInfo: expected topology of cloud 1, got %q", got)
+	}
+
+	if _, err = h.node.NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{}); err != nil {
+		t.Fatalf("NodeGetCapabilities: unexpected error: %v", err)
+	}
+}
+
+func TestIntegration_volumeLifecycle(t *testing.T) {
+	h := newIntegrationHarness(t)
+	ctx := context.Background()
+	capability := &csi.VolumeCapability{
+		AccessMode: singleNodeWriter,
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+	}
+	stagingPath := filepath.Join(h.dir, "staging")
+	targetPath := filepath.Join(h.dir, "pods", "pod-1", "volume")
+
+	created, err := h.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		VolumeCapabilities: []*csi.VolumeCapability{capability},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{{Segments: map[string]string{topologyCloudIDKey: "1"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume: unexpected error: %v", err)
+	}
+	volumeID := created.Volume.VolumeId
+
+	validated, err := h.controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           volumeID,
+		VolumeCapabilities: mountCapabilities(),
+	})
+	if err != nil || validated.Confirmed == nil {
+		t.Fatalf("ValidateVolumeCapabilities: expected confirmed capabilities, got %+v (%v)", validated, err)
+	}
+
+	published, err := h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         volumeID,
+		NodeId:           testNodeID,
+		VolumeCapability: capability,
+		VolumeContext:    created.Volume.VolumeContext,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume: unexpected error: %v", err)
+	}
+
+	stageReq := &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		PublishContext:    published.PublishContext,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  capability,
+		VolumeContext:     created.Volume.VolumeContext,
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = h.node.NodeStageVolume(ctx, stageReq); err != nil {
+			t.Fatalf("NodeStageVolume: unexpected error: %v", err)
+		}
+	}
+	if n := h.exec.count("mkfs.ext4"); n != 0 {
+		t.Errorf("NodeStageVolume: expected the formatted device not to be reformatted, got %d mkfs.ext4 calls", n)
+	}
+	if !h.isMounted(stagingPath) {
+		t.Fatalf("NodeStageVolume: expected %s to be mounted", stagingPath)
+	}
+
+	publishReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeID,
+		PublishContext:    published.PublishContext,
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  capability,
+		VolumeContext:     created.Volume.VolumeContext,
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = h.node.NodePublishVolume(ctx, publishReq); err != nil {
+			t.Fatalf("NodePublishVolume: unexpected error: %v", err)
+		}
+	}
+	if !h.isMounted(targetPath) {
+		t.Fatalf("NodePublishVolume: expected %s to be mounted", targetPath)
+	}
+
+	stats, err := h.node.NodeGetVolumeStats(ctx, &csi.NodeGetVolumeStatsRequest{VolumeId: volumeID, VolumePath: targetPath})
+	if err != nil || len(stats.Usage) == 0 {
+		t.Fatalf("NodeGetVolumeStats: expected usage, got %+v (%v)", stats, err)
+	}
+
+	expanded, err := h.controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      volumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * defaultVolumeSizeInBytes},
+	})
+	if err != nil {
+		t.Fatalf("ControllerExpandVolume: unexpected error: %v", err)
+	}
+	if expanded.NodeExpansionRequired {
+		if _, err = h.node.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{
+			VolumeId:          volumeID,
+			VolumePath:        targetPath,
+			StagingTargetPath: stagingPath,
+			VolumeCapability:  capability,
+			CapacityRange:     &csi.CapacityRange{RequiredBytes: 2 * defaultVolumeSizeInBytes},
+		}); err != nil {
+			t.Fatalf("NodeExpandVolume: unexpected error: %v", err)
+		}
+		if n := h.exec.count("resize2fs"); n != 1 {
+			t.Errorf("NodeExpandVolume: expected the filesystem to be resized once, got %d", n)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = h.node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: targetPath}); err != nil {
+			t.Fatalf("NodeUnpublishVolume: unexpected error: %v", err)
+		}
+		if _, err = h.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: volumeID, StagingTargetPath: stagingPath}); err != nil {
+			t.Fatalf("NodeUnstageVolume: unexpected error: %v", err)
+		}
+	}
+	if h.isMounted(targetPath) || h.isMounted(stagingPath) {
+		t.Fatalf("expected volume to be unmounted, got %+v", h.mounter.MountPoints)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = h.controller.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volumeID, NodeId: testNodeID}); err != nil {
+			t.Fatalf("ControllerUnpublishVolume: unexpected error: %v", err)
+		}
+		if _, err = h.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+			t.Fatalf("DeleteVolume: unexpected error: %v", err)
+		}
+	}
+	if _, ok := h.provider.PersistentStorage(volumeID); ok {
+		t.Errorf("DeleteVolume: expected persistent storage %s to be deleted", volumeID)
+	}
+}
+
+// createPublishStage creates a volume with the given parameters and capability, publishes it to
+// testNodeID and stages it below h.dir. Failures of the controller calls are fatal, the error of
+// NodeStageVolume is returned together with the request to restage or publish the volume.
+func (h *integrationHarness) createPublishStage(t *testing.T, name string, parameters map[string]string, capability *csi.VolumeCapability) (*csi.NodeStageVolumeRequest, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	created, err := h.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               name,
+		VolumeCapabilities: []*csi.VolumeCapability{capability},
+		Parameters:         parameters,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume: unexpected error: %v", err)
+	}
+	published, err := h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         created.Volume.VolumeId,
+		NodeId:           testNodeID,
+		VolumeCapability: capability,
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume: unexpected error: %v", err)
+	}
+
+	stageReq := &csi.NodeStageVolumeRequest{
+		VolumeId:          created.Volume.VolumeId,
+		PublishContext:    published.PublishContext,
+		StagingTargetPath: filepath.Join(h.dir, "staging", name),
+		VolumeCapability:  capability,
+		VolumeContext:     created.Volume.VolumeContext,
+	}
+	_, err = h.node.NodeStageVolume(ctx, stageReq)
+	return stageReq, err
+}
+
+func (h *integrationHarness) isMounted(path string) bool {
+	mountPoints, _ := h.mounter.List()
+	for _, mountPoint := range mountPoints {
+		if mountPoint.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *integrationHarness) mountOptions(path string) []string {
+	mountPoints, _ := h.mounter.List()
+	for _, mountPoint := range mountPoints {
+		if mountPoint.Path == path {
+			return mountPoint.Opts
+		}
+	}
+	return nil
+}
+
+// fakeBlockDevices exposes the persistent storages which the fake backend reports as attached to
+// the node as block devices.
+type fakeBlockDevices struct {
+	provider *fake.Provider
+	nodeID   string
+}
+
+func (f *fakeBlockDevices) devicePathByUUID(volumeUUID string) (string, error) {
+	storages, err := f.provider.ListPersistentStorages(context.Background(), f.provider.TenantID())
+	if err != nil {
+		return "", err
+	}
+	for i := range storages {
+		if storages[i].UUID == volumeUUID && isAttachedToDevice(&storages[i], f.nodeID) {
+			return "/dev/fake-" + volumeUUID, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+func (f *fakeBlockDevices) rescan() error {
+	return nil
+}
+
+// fakeExec simulates the filesystem tools used by the mounter: blkid reports ext4 for every device,
+// as Xelon formats persistent storages before they are attached, unless filesystems overrides it
+// (an empty string for a blank device). mkfs.* records the new filesystem, fsck and the resize
+// tools always succeed.
+type fakeExec struct {
+	mu          sync.Mutex
+	filesystems map[string]string
+	commands    [][]string
+}
+
+var _ exec.Interface = &fakeExec{}
+
+func newFakeExec() *fakeExec {
+	return &fakeExec{filesystems: make(map[string]string)}
+}
+
+func (f *fakeExec) Command(cmd string, args ...string) exec.Cmd {
+	return testingexec.InitFakeCmd(&testingexec.FakeCmd{
+		CombinedOutputScript: []testingexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				output, err := f.run(cmd, args...)
+				return output, nil, err
+			},
+		},
+	}, cmd, args...)
+}
+
+func (f *fakeExec) CommandContext(_ context.Context, cmd string, args ...string) exec.Cmd {
+	return f.Command(cmd, args...)
+}
+
+func (f *fakeExec) LookPath(file string) (string, error) {
+	return "/usr/sbin/" + file, nil
+}
+
+func (f *fakeExec) run(cmd string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.commands = append(f.commands, append([]string{cmd}, args...))
+	device := ""
+	if len(args) > 0 {
+		device = args[len(args)-1]
+	}
+
+	switch {
+	case cmd == "blkid":
+		fsType, ok := f.filesystems[device]
+		if !ok {
+			fsType = defaultFsType
+		}
+		if fsType == "" {
+			return nil, testingexec.FakeExitError{Status: 2}
+		}
+		return []byte("DEVNAME=" + device + "\nTYPE=" + fsType + "\n"), nil
+	case strings.HasPrefix(cmd, "mkfs."):
+		f.filesystems[device] = strings.TrimPrefix(cmd, "mkfs.")
+		return nil, nil
+	case cmd == "fsck", cmd == "resize2fs", cmd == "tune2fs":
+		return nil, nil
+	}
+	return nil, errors.New("unexpected command " + cmd)
+}
+
+// setFilesystem sets the filesystem blkid reports for the device, an empty string for a blank one.
+func (f *fakeExec) setFilesystem(device, fsType string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.filesystems[device] = fsType
+}
+
+// count returns how often the given command was run.
+func (f *fakeExec) count(cmd string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, c := range f.commands {
+		if c[0] == cmd {
+			n++
+		}
+	}
+	return n
+}
+
+// args returns the arguments of the last run of the given command.
+func (f *fakeExec) args(cmd string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := len(f.commands) - 1; i >= 0; i-- {
+		if f.commands[i][0] == cmd {
+			return f.commands[i][1:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package driver
+
+import (
+	"sync"
+)
+
+// keyLocks serializes operations by key (e.g. volume or device id) without blocking. As recommended
+// by the CSI spec, a conflicting request is not queued, but fails with codes.Aborted and is retried
+// by the CO.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]struct{}
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{
+		locks: make(map[string]struct{}),
+	}
+}
+
+// tryAcquire locks all given keys at once. If any of the keys is already locked, nothing is
+// locked and false is returned.
+func (l *keyLocks) tryAcquire(keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if _, ok := l.locks[key]; ok {
+			return false
+		}
+	}
+	for _, key := range keys {
+		l.locks[key] = struct{}{}
+	}
+	return true
+}
+
+// release unlocks all given keys.
+func (l *keyLocks) release(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		delete(l.locks, key)
+	}
+}
+
+func volumeLockKey(volumeID string) string {
+	return "volume/" + volumeID
+}
+
+func volumeNameLockKey(volumeName string) string {
+	return "volume-name/" + volumeName
+}
+
+func deviceLockKey(deviceID string) string {
+	return "device/" + deviceID
+}
@@ -0,0 +1,177 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestKeyLocks_tryAcquire(t *testing.T) {
+	locks := newKeyLocks()
+
+	if !locks.tryAcquire("a") {
+		t.Fatal("expected to acquire free key a")
+	}
+	if locks.tryAcquire("a") {
+		t.Fatal("expected key a to be locked")
+	}
+	if locks.tryAcquire("b", "a") {
+		t.Fatal("expected keys b and a not to be acquired while a is locked")
+	}
+	// b must not be left locked by the failed attempt above
+	if !locks.tryAcquire("b") {
+		t.Fatal("expected to acquire free key b")
+	}
+
+	locks.release("a", "b")
+	if !locks.tryAcquire("a", "b") {
+		t.Fatal("expected to acquire released keys a and b")
+	}
+}
+
+func TestKeyLocks_concurrentAcquire(t *testing.T) {
+	const goroutines = 100
+
+	locks := newKeyLocks()
+	start := make(chan struct{})
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if locks.tryAcquire(volumeLockKey("vol-1"), deviceLockKey("dev-1")) {
+				acquired.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := acquired.Load(); got != 1 {
+		t.Fatalf("expected exactly one goroutine to acquire the lock, got %d", got)
+	}
+}
+
+func TestKeyLocks_concurrentAcquireRelease(t *testing.T) {
+	const goroutines = 50
+	const iterations = 200
+
+	locks := newKeyLocks()
+	var holders atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				if !locks.tryAcquire("shared") {
+					continue
+				}
+				if n := holders.Add(1); n != 1 {
+					t.Errorf("expected a single holder of the lock, got %d", n)
+				}
+				holders.Add(-1)
+				locks.release("shared")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestController_operationInProgress(t *testing.T) {
+	d := &Driver{
+		controllerService: &controllerService{
+			cloudIDs:       map[string]struct{}{"1": {}},
+			defaultCloudID: "1",
+			locks:          newKeyLocks(),
+			operations:     newOperationTracker(volumeStatusCheckTimeout),
+		},
+	}
+	volumeCapabilities := []*csi.VolumeCapability{{
+		AccessMode: singleNodeWriter,
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}}
+
+	tests := map[string]struct {
+		lockedKey string
+		call      func() error
+	}{
+		"CreateVolume": {
+			lockedKey: volumeNameLockKey("pvc-1"),
+			call: func() error {
+				_, err := d.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+					Name:               "pvc-1",
+					VolumeCapabilities: volumeCapabilities,
+				})
+				return err
+			},
+		},
+		"DeleteVolume": {
+			lockedKey: volumeLockKey("vol-1"),
+			call: func() error {
+				_, err := d.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "vol-1"})
+				return err
+			},
+		},
+		"ControllerPublishVolume locked volume": {
+			lockedKey: volumeLockKey("vol-1"),
+			call: func() error {
+				_, err := d.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+					VolumeId:         "vol-1",
+					NodeId:           "dev-1",
+					VolumeCapability: volumeCapabilities[0],
+				})
+				return err
+			},
+		},
+		"ControllerPublishVolume locked device": {
+			lockedKey: deviceLockKey("dev-1"),
+			call: func() error {
+				_, err := d.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+					VolumeId:         "vol-2",
+					NodeId:           "dev-1",
+					VolumeCapability: volumeCapabilities[0],
+				})
+				return err
+			},
+		},
+		"ControllerUnpublishVolume": {
+			lockedKey: deviceLockKey("dev-1"),
+			call: func() error {
+				_, err := d.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+					VolumeId: "vol-2",
+					NodeId:   "dev-1",
+				})
+				return err
+			},
+		},
+		"ControllerExpandVolume": {
+			lockedKey: volumeLockKey("vol-1"),
+			call: func() error {
+				_, err := d.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{VolumeId: "vol-1"})
+				return err
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if !d.locks.tryAcquire(tt.lockedKey) {
+				t.Fatalf("failed to acquire %s", tt.lockedKey)
+			}
+			defer d.locks.release(tt.lockedKey)
+
+			err := tt.call()
+			if got := status.Code(err); got != codes.Aborted {
+				t.Fatalf("expected code %s, got %s (%v)", codes.Aborted, got, err)
+			}
+		})
+	}
+}
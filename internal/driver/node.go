@@ -3,9 +3,12 @@ package driver
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/sys/unix"
@@ -20,8 +23,6 @@ import (
 
 const (
 	diskUUIDPath = "/dev/disk/by-uuid"
-
-	maxVolumeCountPerNode = 15
 )
 
 var (
@@ -29,38 +30,93 @@ var (
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 	}
 )
 
+// blockDevices finds and rescans the block devices of persistent storages attached to the node.
+type blockDevices interface {
+	// devicePathByUUID returns the block device of the persistent storage with the given uuid.
+	devicePathByUUID(volumeUUID string) (string, error)
+	// rescan makes the kernel detect attached, detached and resized persistent storages.
+	rescan() error
+}
+
+// hostBlockDevices looks up block devices in /dev/disk/by-uuid and rescans them via sysfs.
+type hostBlockDevices struct{}
+
+func (hostBlockDevices) devicePathByUUID(volumeUUID string) (string, error) {
+	return getDevicePathByUUID(volumeUUID)
+}
+
+func (hostBlockDevices) rescan() error {
+	return cloud.RescanSCSIDevices()
+}
+
 type nodeService struct {
+	devices blockDevices
 	mounter *mount.SafeFormatAndMount
 
-	nodeID         string
-	nodeName       string
-	rescanOnResize bool
+	maxVolumesPerNode int
+	nodeCloudID       string
+	nodeID            string
+	nodeName          string
+	rescanOnResize    bool
 }
 
 func newNodeService(ctx context.Context, opts *Options) (*nodeService, error) {
-	klog.V(2).InfoS("Initialize node service")
-
 	metadata, err := cloud.RetrieveMetadata(ctx)
 	if err != nil {
 		return nil, err
 	}
+
+	// the Xelon API is only used to look up the cloud of the node if neither the node label nor
+	// the flag sets it, so the node plugin doesn't need the credentials of the tenant
+	var xelonProvider cloud.Provider
+	if opts.XelonToken != "" {
+		xelonProvider, err = newXelonProvider(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mounter := &mount.SafeFormatAndMount{
+		Interface: mount.New(""),
+		Exec:      exec.New(),
+	}
+	return newNodeServiceWithDependencies(ctx, opts, metadata, xelonProvider, mounter, hostBlockDevices{})
+}
+
+// newNodeServiceWithDependencies creates the node service for the device described by metadata,
+// which allows to run the node service with a fake Xelon backend, mounter and block devices.
+// xelonProvider may be nil, then the cloud of the node is only taken from its label or the flag.
+func newNodeServiceWithDependencies(ctx context.Context, opts *Options, metadata *cloud.Metadata, xelonProvider cloud.Provider, mounter *mount.SafeFormatAndMount, devices blockDevices) (*nodeService, error) {
+	klog.V(2).InfoS("Initialize node service")
 	klog.V(5).InfoS("Retrieved device metadata", "metadata", *metadata)
 
 	if metadata.LocalVMID == "" {
 		return nil, errors.New("localVMID cannot be empty")
 	}
 
+	cloudID := nodeCloudID(ctx, opts, metadata, xelonProvider)
+
+	maxVolumesPerNode := opts.MaxVolumesPerNode
+	if metadata.MaxVolumesPerNode > 0 {
+		klog.V(2).InfoS("Overriding maximum number of volumes via node label",
+			"label", LabelMaxVolumesPerNode,
+			"max_volumes_per_node", metadata.MaxVolumesPerNode,
+		)
+		maxVolumesPerNode = metadata.MaxVolumesPerNode
+	}
+
 	return &nodeService{
-		mounter: &mount.SafeFormatAndMount{
-			Interface: mount.New(""),
-			Exec:      exec.New(),
-		},
-		nodeID:         metadata.LocalVMID,
-		nodeName:       metadata.Name,
-		rescanOnResize: opts.RescanOnResize,
+		devices:           devices,
+		mounter:           mounter,
+		maxVolumesPerNode: maxVolumesPerNode,
+		nodeCloudID:       cloudID,
+		nodeID:            metadata.LocalVMID,
+		nodeName:          metadata.Name,
+		rescanOnResize:    opts.RescanOnResize,
 	}, nil
 }
 
@@ -74,8 +130,12 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 	if req.VolumeCapability == nil {
 		return nil, status.Errorf(codes.InvalidArgument, "volume capability not provided")
 	}
+	if err := validateVolumeCapability(req.VolumeCapability); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s: %v", req.VolumeId, err)
+	}
 
 	klog.V(2).InfoS("Mounting volume to staging path",
+		"access_mode", req.VolumeCapability.GetAccessMode().GetMode(),
 		"method", "NodeStageVolume",
 		"node_name", d.nodeName,
 		"staging_target_path", req.StagingTargetPath,
@@ -91,19 +151,29 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 		return nil, status.Errorf(codes.InvalidArgument, "%s not found in publish context of volume %s", xelonStorageUUID, req.VolumeId)
 	}
 
+	fsType, err := volumeFsType(req.VolumeCapability, req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s: %v", req.VolumeId, err)
+	}
+	tune2fsArgs, err := formatOptions(fsType, req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s: %v", req.VolumeId, err)
+	}
+
 	if d.rescanOnResize {
-		if err := cloud.RescanSCSIDevices(); err != nil {
+		if err := d.devices.rescan(); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to rescan volume: %s", err)
 		}
 	}
 
-	devicePath, err := getDevicePathByUUID(volumeUUID)
+	devicePath, err := d.devices.devicePathByUUID(volumeUUID)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, status.Errorf(codes.NotFound, "volume %s is not mounted on node yet", req.VolumeId)
 		}
 		return nil, status.Errorf(codes.Internal, "error getting device path for volume with ID %s: %s", req.VolumeId, err.Error())
 	}
+
 	target := req.StagingTargetPath
 
 	klog.V(5).InfoS("Determining if staging target is not a mount point",
@@ -127,12 +197,37 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 		}
 	}
 
+	// never mount a volume with another filesystem than it is formatted with, mkfs is only run on
+	// unformatted devices
+	existingFsType, err := d.mounter.GetDiskFormat(devicePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to determine filesystem of volume %s: %v", req.VolumeId, err)
+	}
+	if existingFsType != "" && existingFsType != fsType {
+		if !notMnt {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s is already staged at %s with filesystem %s, requested %s", req.VolumeId, target, existingFsType, fsType)
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is formatted with filesystem %s, requested %s", req.VolumeId, existingFsType, fsType)
+	}
+
+	// reader-only volumes are never formatted or tuned, as other nodes may have them mounted
+	readOnly := isReadOnlyAccessMode(req.VolumeCapability.GetAccessMode().GetMode())
+	if readOnly && existingFsType == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume %s is not formatted and can't be staged with reader-only access mode", req.VolumeId)
+	}
+
 	// volume mount
 	if notMnt {
 		mountFlags := req.VolumeCapability.GetMount().GetMountFlags()
+		if readOnly {
+			// a read-only ext4 mount still replays a dirty journal, e.g. after its last writer
+			// crashed, and writes to a device other readers may have mounted; noload skips it
+			mountFlags = append([]string{"ro", "noload"}, mountFlags...)
+		}
 
 		klog.V(5).InfoS("Mounting target",
 			"device_path", devicePath,
+			"fs_type", fsType,
 			"method", "NodeStageVolume",
 			"mount_flags", mountFlags,
 			"node_id", d.nodeID,
@@ -140,12 +235,27 @@ func (d *Driver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequ
 			"staging_target_path", target,
 			"volume_id", req.VolumeId,
 		)
-		err := d.mounter.FormatAndMount(devicePath, target, "ext4", mountFlags)
+		err := d.mounter.FormatAndMount(devicePath, target, fsType, mountFlags)
 		if err != nil {
 			return nil, status.Error(codes.Internal, err.Error())
 		}
 	}
 
+	// tune2fs is idempotent, so it is applied on every stage in case a previous attempt failed
+	// after mounting
+	if len(tune2fsArgs) > 0 && !readOnly {
+		klog.V(5).InfoS("Tuning filesystem",
+			"device_path", devicePath,
+			"method", "NodeStageVolume",
+			"tune2fs_args", tune2fsArgs,
+			"volume_id", req.VolumeId,
+		)
+		output, err := d.mounter.Exec.Command("tune2fs", append(tune2fsArgs, devicePath)...).CombinedOutput()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to tune filesystem of volume %s: %v: %s", req.VolumeId, err, output)
+		}
+	}
+
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
@@ -171,7 +281,7 @@ func (d *Driver) NodeUnstageVolume(_ context.Context, req *csi.NodeUnstageVolume
 	}
 
 	if d.rescanOnResize {
-		if err = cloud.RescanSCSIDevices(); err != nil {
+		if err = d.devices.rescan(); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to rescan volume: %s", err)
 		}
 	}
@@ -192,9 +302,18 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 	if req.VolumeCapability == nil {
 		return nil, status.Errorf(codes.InvalidArgument, "volume capability not provided")
 	}
+	if err := validateVolumeCapability(req.VolumeCapability); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s: %v", req.VolumeId, err)
+	}
+
+	fsType, err := volumeFsType(req.VolumeCapability, req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s: %v", req.VolumeId, err)
+	}
 
 	source := req.StagingTargetPath
 	target := req.TargetPath
+	readOnly := isReadOnlyPublish(req)
 
 	klog.V(5).InfoS("Determining if target is not a mount point",
 		"method", "NodePublishVolume",
@@ -218,27 +337,66 @@ func (d *Driver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolume
 		}
 	}
 
-	if notMnt {
-		mountFlags := []string{"bind"}
-		mountFlags = append(mountFlags, req.VolumeCapability.GetMount().GetMountFlags()...)
-
-		klog.V(5).InfoS("Mounting target",
-			"method", "NodePublishVolume",
-			"mount_flags", mountFlags,
-			"node_name", d.nodeName,
-			"source", source,
-			"target", target,
-			"volume_id", req.VolumeId,
-		)
-		err := d.mounter.Mount(source, target, "ext4", mountFlags)
-		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+	if !notMnt {
+		if err = d.checkPublishedReadOnly(target, readOnly); err != nil {
+			return nil, err
 		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	// the mounter remounts read-only bind mounts, as the kernel ignores ro when binding
+	mountFlags := bindMountFlags(readOnly)
+	mountFlags = append(mountFlags, req.VolumeCapability.GetMount().GetMountFlags()...)
+
+	klog.V(5).InfoS("Mounting target",
+		"fs_type", fsType,
+		"method", "NodePublishVolume",
+		"mount_flags", mountFlags,
+		"node_name", d.nodeName,
+		"source", source,
+		"target", target,
+		"volume_id", req.VolumeId,
+	)
+	if err = d.mounter.Mount(source, target, fsType, mountFlags); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// checkPublishedReadOnly returns AlreadyExists if the target is already mounted, but not with the
+// requested read-only mode.
+func (d *Driver) checkPublishedReadOnly(target string, readOnly bool) error {
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to list mount points: %v", err)
+	}
+	for _, mountPoint := range mountPoints {
+		if mountPoint.Path != target {
+			continue
+		}
+		if mounted := slices.Contains(mountPoint.Opts, "ro"); mounted != readOnly {
+			return status.Errorf(codes.AlreadyExists, "target %s is already published with read-only %t, requested read-only %t", target, mounted, readOnly)
+		}
+		return nil
+	}
+	return nil
+}
+
+// isReadOnlyPublish returns true if the volume must be published read-only, either as requested
+// by the CO or because of a reader-only access mode.
+func isReadOnlyPublish(req *csi.NodePublishVolumeRequest) bool {
+	return req.Readonly || isReadOnlyAccessMode(req.VolumeCapability.GetAccessMode().GetMode())
+}
+
+// bindMountFlags returns the flags to bind mount a published volume.
+func bindMountFlags(readOnly bool) []string {
+	if readOnly {
+		return []string{"bind", "ro"}
+	}
+	return []string{"bind"}
+}
+
 func (d *Driver) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	if req.VolumeId == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "volume id not provided")
@@ -277,10 +435,13 @@ func (d *Driver) NodeGetVolumeStats(_ context.Context, req *csi.NodeGetVolumeSta
 	)
 	notMnt, err := d.mounter.IsLikelyNotMountPoint(req.VolumePath)
 	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, status.Errorf(codes.NotFound, "volume path does not exist: %s", req.VolumePath)
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	if notMnt {
-		return nil, status.Errorf(codes.Internal, "volume path is not mounted: %s", req.VolumePath)
+		return nil, status.Errorf(codes.NotFound, "volume path is not mounted: %s", req.VolumePath)
 	}
 
 	fs := &unix.Statfs_t{}
@@ -325,6 +486,9 @@ func (d *Driver) NodeExpandVolume(_ context.Context, req *csi.NodeExpandVolumeRe
 	if req.VolumeId == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "volume id not provided")
 	}
+	if req.VolumePath == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volume path not provided")
+	}
 
 	klog.V(2).InfoS("Expanding volume",
 		"method", "NodeExpandVolume",
@@ -338,15 +502,29 @@ func (d *Driver) NodeExpandVolume(_ context.Context, req *csi.NodeExpandVolumeRe
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to determine mount path for %s: %s", req.VolumePath, err)
 	}
+	if devicePath == "" {
+		return nil, status.Errorf(codes.NotFound, "volume %s is not mounted at %s", req.VolumeId, req.VolumePath)
+	}
 
 	if d.rescanOnResize {
-		if err = cloud.RescanSCSIDevices(); err != nil {
+		if err = d.devices.rescan(); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to rescan volume: %s", err)
 		}
 	}
 
+	// only filesystems the driver stages are grown, ResizeFs picks the grow tool by the
+	// filesystem on the device
+	fsType, err := d.mounter.GetDiskFormat(devicePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to determine filesystem of volume %s: %v", req.VolumeId, err)
+	}
+	if _, err = parseFsType(fsType); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "cannot resize filesystem %q of volume %s: %v", fsType, req.VolumeId, err)
+	}
+
 	klog.V(5).InfoS("Resizing device path",
 		"device_path", devicePath,
+		"fs_type", fsType,
 		"method", "NodeExpandVolume",
 		"volume_path", req.VolumePath,
 	)
@@ -391,17 +569,82 @@ func (d *Driver) NodeGetCapabilities(_ context.Context, req *csi.NodeGetCapabili
 
 func (d *Driver) NodeGetInfo(_ context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
 	klog.V(5).InfoS("Get info about the current node",
+		"cloud_id", d.nodeCloudID,
 		"method", "NodeGetInfo",
 		"node_id", d.nodeID,
 		"node_name", d.nodeName,
-		"max_volumes_per_node", maxVolumeCountPerNode,
+		"max_volumes_per_node", d.maxVolumesPerNode,
 		"req", *req,
 	)
 
-	return &csi.NodeGetInfoResponse{
+	resp := &csi.NodeGetInfoResponse{
 		NodeId:            d.nodeID,
-		MaxVolumesPerNode: maxVolumeCountPerNode,
-	}, nil
+		MaxVolumesPerNode: int64(d.maxVolumesPerNode),
+	}
+	if d.nodeCloudID != "" {
+		resp.AccessibleTopology = &csi.Topology{
+			Segments: map[string]string{topologyCloudIDKey: d.nodeCloudID},
+		}
+	}
+	return resp, nil
+}
+
+// nodeCloudID returns the Xelon cloud of the device on which the node service is running, set by
+// the node label, by --xelon-cloud-id or, if credentials are provided, looked up in the Xelon API.
+// If the cloud can't be determined, an empty string is returned and no topology is reported.
+func nodeCloudID(ctx context.Context, opts *Options, metadata *cloud.Metadata, xelonProvider cloud.Provider) string {
+	if metadata.CloudID != "" {
+		klog.V(2).InfoS("Using cloud of the node label",
+			"cloud_id", metadata.CloudID,
+			"label", LabelXelonCloudID,
+		)
+		return metadata.CloudID
+	}
+	if len(opts.XelonCloudIDs) == 1 {
+		return opts.XelonCloudIDs[0]
+	}
+	if len(opts.XelonCloudIDs) > 1 {
+		klog.InfoS("Ignoring several Xelon cloud ids, the node belongs to a single cloud",
+			"cloud_ids", opts.XelonCloudIDs,
+		)
+	}
+
+	if xelonProvider == nil {
+		klog.InfoS("Xelon cloud of the node is not set, node topology will not be reported",
+			"label", LabelXelonCloudID,
+			"node_id", metadata.LocalVMID,
+		)
+		return ""
+	}
+	cloudID, err := retrieveCloudID(ctx, xelonProvider, metadata.LocalVMID)
+	if err != nil {
+		klog.ErrorS(err, "Failed to retrieve Xelon cloud of the node, node topology will not be reported",
+			"node_id", metadata.LocalVMID,
+		)
+		return ""
+	}
+	return cloudID
+}
+
+// retrieveCloudID returns the Xelon cloud of the device on which the node service is running.
+func retrieveCloudID(ctx context.Context, xelonProvider cloud.Provider, localVMID string) (string, error) {
+	tenant, err := xelonProvider.GetCurrentTenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	klog.V(5).InfoS("Fetched info about tenant", "tenant_id", tenant.TenantID)
+
+	device, err := xelonProvider.GetDevice(ctx, tenant.TenantID, localVMID)
+	if err != nil {
+		return "", fmt.Errorf("error getting device %v: %w", localVMID, err)
+	}
+	if device.Device == nil || device.Device.LocalVMDetails == nil {
+		return "", fmt.Errorf("device %v has no details", localVMID)
+	}
+	cloudID := strconv.Itoa(device.Device.LocalVMDetails.HVSystemID)
+	klog.V(5).InfoS("Fetched cloud of device", "cloud_id", cloudID, "node_id", localVMID)
+
+	return cloudID, nil
 }
 
 func getDevicePathByUUID(volumeUUID string) (string, error) {
@@ -0,0 +1,314 @@
+package driver
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud"
+)
+
+func TestNode_stageFsTypeMismatch(t *testing.T) {
+	h := newIntegrationHarness(t)
+	ctx := context.Background()
+
+	stageReq, err := h.createPublishStage(t, "pvc-1", nil, mountCapabilities()[0])
+	if err != nil {
+		t.Fatalf("NodeStageVolume: unexpected error: %v", err)
+	}
+
+	// a persistent storage reformatted outside of the driver is neither reformatted nor mounted
+	h.exec.setFilesystem("/dev/fake-"+stageReq.PublishContext[xelonStorageUUID], "xfs")
+	_, err = h.node.NodeStageVolume(ctx, stageReq)
+	assertCode(t, err, codes.AlreadyExists)
+
+	if _, err = h.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: stageReq.VolumeId, StagingTargetPath: stageReq.StagingTargetPath}); err != nil {
+		t.Fatalf("NodeUnstageVolume: unexpected error: %v", err)
+	}
+	_, err = h.node.NodeStageVolume(ctx, stageReq)
+	assertCode(t, err, codes.FailedPrecondition)
+	if n := h.exec.count("mkfs.ext4"); n != 0 {
+		t.Errorf("expected the volume not to be reformatted, got %d mkfs.ext4 calls", n)
+	}
+}
+
+func TestNode_formatOptions(t *testing.T) {
+	tests := map[string]struct {
+		parameters  map[string]string
+		tune2fsArgs string
+	}{
+		"reserved blocks": {
+			parameters:  map[string]string{parameterReservedBlocksPercentage: "5"},
+			tune2fsArgs: "-m 5",
+		},
+		"defaults": {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newIntegrationHarness(t)
+
+			stageReq, err := h.createPublishStage(t, "pvc-1", tt.parameters, mountCapabilities()[0])
+			if err != nil {
+				t.Fatalf("NodeStageVolume: unexpected error: %v", err)
+			}
+
+			devicePath := "/dev/fake-" + stageReq.PublishContext[xelonStorageUUID]
+			if tt.tune2fsArgs != "" {
+				if got := strings.Join(h.exec.args("tune2fs"), " "); got != tt.tune2fsArgs+" "+devicePath {
+					t.Errorf("expected tune2fs %s %s, got %s", tt.tune2fsArgs, devicePath, got)
+				}
+			} else if n := h.exec.count("tune2fs"); n != 0 {
+				t.Errorf("expected no tune2fs call, got %d", n)
+			}
+		})
+	}
+}
+
+func TestNode_readOnly(t *testing.T) {
+	h := newIntegrationHarness(t)
+	ctx := context.Background()
+	readerCapability := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY},
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	}
+
+	stageReq, err := h.createPublishStage(t, "pvc-1", nil, mountCapabilities()[0])
+	if err != nil {
+		t.Fatalf("NodeStageVolume: unexpected error: %v", err)
+	}
+	publishReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          stageReq.VolumeId,
+		PublishContext:    stageReq.PublishContext,
+		StagingTargetPath: stageReq.StagingTargetPath,
+		TargetPath:        filepath.Join(h.dir, "pods", "pod-1", "volume"),
+		VolumeCapability:  mountCapabilities()[0],
+		Readonly:          true,
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = h.node.NodePublishVolume(ctx, publishReq); err != nil {
+			t.Fatalf("NodePublishVolume: unexpected error: %v", err)
+		}
+	}
+	if opts := h.mountOptions(publishReq.TargetPath); !slices.Contains(opts, "ro") {
+		t.Errorf("NodePublishVolume: expected read-only mount, got options %v", opts)
+	}
+
+	// the target is already published read-only
+	publishReq.Readonly = false
+	_, err = h.node.NodePublishVolume(ctx, publishReq)
+	assertCode(t, err, codes.AlreadyExists)
+
+	// reader-only access modes are published read-only, even if the CO doesn't request it
+	publishReq.TargetPath = filepath.Join(h.dir, "pods", "pod-2", "volume")
+	publishReq.VolumeCapability = readerCapability
+	if _, err = h.node.NodePublishVolume(ctx, publishReq); err != nil {
+		t.Fatalf("NodePublishVolume: unexpected error: %v", err)
+	}
+	if opts := h.mountOptions(publishReq.TargetPath); !slices.Contains(opts, "ro") {
+		t.Errorf("NodePublishVolume: expected read-only mount, got options %v", opts)
+	}
+
+	// the formatted volume is staged read-only by a reader
+	if _, err = h.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: stageReq.VolumeId, StagingTargetPath: stageReq.StagingTargetPath}); err != nil {
+		t.Fatalf("NodeUnstageVolume: unexpected error: %v", err)
+	}
+	stageReq.VolumeCapability = readerCapability
+	if _, err = h.node.NodeStageVolume(ctx, stageReq); err != nil {
+		t.Fatalf("NodeStageVolume: unexpected error: %v", err)
+	}
+	if opts := h.mountOptions(stageReq.StagingTargetPath); !slices.Contains(opts, "ro") || !slices.Contains(opts, "noload") {
+		t.Errorf("NodeStageVolume: expected read-only mount without journal replay, got options %v", opts)
+	}
+
+	// a blank device can't be staged by a reader
+	if _, err = h.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: stageReq.VolumeId, StagingTargetPath: stageReq.StagingTargetPath}); err != nil {
+		t.Fatalf("NodeUnstageVolume: unexpected error: %v", err)
+	}
+	h.exec.setFilesystem("/dev/fake-"+stageReq.PublishContext[xelonStorageUUID], "")
+	_, err = h.node.NodeStageVolume(ctx, stageReq)
+	assertCode(t, err, codes.FailedPrecondition)
+	if n := h.exec.count("mkfs.ext4"); n != 0 {
+		t.Errorf("NodeStageVolume: expected a reader not to format the volume, got %d mkfs.ext4 calls", n)
+	}
+}
+
+func TestNode_singleNodeMultiWriterCapability(t *testing.T) {
+	h := newIntegrationHarness(t)
+	ctx := context.Background()
+
+	controllerCapabilities, err := h.controller.ControllerGetCapabilities(ctx, &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("ControllerGetCapabilities: unexpected error: %v", err)
+	}
+	if !slices.ContainsFunc(controllerCapabilities.Capabilities, func(c *csi.ControllerServiceCapability) bool {
+		return c.GetRpc().GetType() == csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER
+	}) {
+		t.Errorf("ControllerGetCapabilities: expected SINGLE_NODE_MULTI_WRITER, got %v", controllerCapabilities.Capabilities)
+	}
+	nodeCapabilities, err := h.node.NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetCapabilities: unexpected error: %v", err)
+	}
+	if !slices.ContainsFunc(nodeCapabilities.Capabilities, func(c *csi.NodeServiceCapability) bool {
+		return c.GetRpc().GetType() == csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER
+	}) {
+		t.Errorf("NodeGetCapabilities: expected SINGLE_NODE_MULTI_WRITER, got %v", nodeCapabilities.Capabilities)
+	}
+}
+
+func TestNode_accessModes(t *testing.T) {
+	tests := map[csi.VolumeCapability_AccessMode_Mode]struct {
+		supported bool
+		readOnly  bool
+	}{
+		csi.VolumeCapability_AccessMode_UNKNOWN:                   {},
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER:        {supported: true},
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:   {supported: true, readOnly: true},
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:    {supported: true, readOnly: true},
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER:  {},
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:   {},
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER: {supported: true},
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:  {supported: true},
+	}
+
+	for mode, tt := range tests {
+		t.Run(mode.String(), func(t *testing.T) {
+			h := newIntegrationHarness(t)
+			ctx := context.Background()
+			capability := &csi.VolumeCapability{
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			}
+
+			_, err := h.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "pvc-2", VolumeCapabilities: []*csi.VolumeCapability{capability}})
+			if !tt.supported {
+				assertCode(t, err, codes.InvalidArgument)
+			} else if err != nil {
+				t.Fatalf("CreateVolume: unexpected error: %v", err)
+			}
+
+			// the volume is created, published and staged with a supported mode first, to check
+			// that the other RPCs reject unsupported modes, too
+			stageReq, err := h.createPublishStage(t, "pvc-1", nil, mountCapabilities()[0])
+			if err != nil {
+				t.Fatalf("NodeStageVolume: unexpected error: %v", err)
+			}
+			if _, err = h.node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: stageReq.VolumeId, StagingTargetPath: stageReq.StagingTargetPath}); err != nil {
+				t.Fatalf("NodeUnstageVolume: unexpected error: %v", err)
+			}
+			if _, err = h.controller.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: stageReq.VolumeId, NodeId: testNodeID}); err != nil {
+				t.Fatalf("ControllerUnpublishVolume: unexpected error: %v", err)
+			}
+
+			validated, err := h.controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           stageReq.VolumeId,
+				VolumeCapabilities: []*csi.VolumeCapability{capability},
+			})
+			if err != nil {
+				t.Fatalf("ValidateVolumeCapabilities: unexpected error: %v", err)
+			}
+			if confirmed := validated.Confirmed != nil; confirmed != tt.supported {
+				t.Errorf("ValidateVolumeCapabilities: expected confirmed %t, got %+v", tt.supported, validated)
+			}
+
+			_, err = h.controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+				VolumeId:         stageReq.VolumeId,
+				NodeId:           testNodeID,
+				VolumeCapability: capability,
+			})
+			if !tt.supported {
+				assertCode(t, err, codes.InvalidArgument)
+			} else if err != nil {
+				t.Fatalf("ControllerPublishVolume: unexpected error: %v", err)
+			}
+
+			stageReq.VolumeCapability = capability
+			_, err = h.node.NodeStageVolume(ctx, stageReq)
+			if !tt.supported {
+				assertCode(t, err, codes.InvalidArgument)
+				return
+			}
+			if err != nil {
+				t.Fatalf("NodeStageVolume: unexpected error: %v", err)
+			}
+			if readOnly := slices.Contains(h.mountOptions(stageReq.StagingTargetPath), "ro"); readOnly != tt.readOnly {
+				t.Errorf("NodeStageVolume: expected read-only %t, got options %v", tt.readOnly, h.mountOptions(stageReq.StagingTargetPath))
+			}
+		})
+	}
+}
+
+func TestNode_cloudID(t *testing.T) {
+	tests := map[string]struct {
+		labelCloudID string
+		cloudIDs     []string
+		provider     cloud.Provider
+		nodeID       string
+		want         string
+	}{
+		"node label": {
+			labelCloudID: "2",
+			cloudIDs:     []string{"3"},
+			provider:     newFakeProvider(),
+			nodeID:       "dev-1",
+			want:         "2",
+		},
+		"flag": {
+			cloudIDs: []string{"2"},
+			nodeID:   "dev-1",
+			want:     "2",
+		},
+		"Xelon API": {
+			provider: newFakeProvider(),
+			nodeID:   "dev-3",
+			want:     "2",
+		},
+		"Xelon API with several flags": {
+			cloudIDs: []string{"1", "2"},
+			provider: newFakeProvider(),
+			nodeID:   "dev-3",
+			want:     "2",
+		},
+		"unknown device": {
+			provider: newFakeProvider(),
+			nodeID:   "missing",
+			want:     "",
+		},
+		"neither label, flag nor credentials": {
+			nodeID: "dev-1",
+			want:   "",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ns, err := newNodeServiceWithDependencies(context.Background(),
+				&Options{XelonCloudIDs: tt.cloudIDs},
+				&cloud.Metadata{CloudID: tt.labelCloudID, LocalVMID: tt.nodeID, Name: "node-1"},
+				tt.provider,
+				nil,
+				nil,
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			info, err := (&Driver{nodeService: ns}).NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := info.AccessibleTopology.GetSegments()[topologyCloudIDKey]; got != tt.want {
+				t.Errorf("expected topology of cloud %q, got %q", tt.want, got)
+			}
+			if tt.want == "" && info.AccessibleTopology != nil {
+				t.Errorf("expected no topology, got %v", info.AccessibleTopology)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+// finishedOperationRetention is how long the result of a finished operation is kept for a retried
+// gRPC call to pick it up. Results which were never picked up are dropped afterwards.
+const finishedOperationRetention = 15 * time.Minute
+
+// operation is a long-running Xelon job (e.g. creating or extending a persistent storage) which
+// continues in the background after the gRPC call that started it has returned.
+type operation struct {
+	done      chan struct{}
+	startedAt time.Time
+
+	// finishedAt, storage and err are set once done is closed
+	finishedAt time.Time
+	storage    *xelon.PersistentStorage
+	err        error
+}
+
+// finishedBefore returns true if the operation is done and finished before the given time.
+func (op *operation) finishedBefore(t time.Time) bool {
+	select {
+	case <-op.done:
+		return op.finishedAt.Before(t)
+	default:
+		return false
+	}
+}
+
+// operationTracker keeps track of running operations by key, so that a retried gRPC call picks
+// up the result of an operation instead of starting the same Xelon job again. Operations are
+// kept in memory only, after a restart the controller resumes from the state reported by Xelon.
+// Finished operations are kept until a retried call picks them up, but at most for
+// finishedOperationRetention.
+type operationTracker struct {
+	mu         sync.Mutex
+	operations map[string]*operation
+	timeout    time.Duration
+}
+
+func newOperationTracker(timeout time.Duration) *operationTracker {
+	return &operationTracker{
+		operations: make(map[string]*operation),
+		timeout:    timeout,
+	}
+}
+
+// start runs fn in the background and tracks it under the given key. If an operation with the same
+// key is already tracked, fn is not executed and the tracked operation is returned.
+func (t *operationTracker) start(key string, fn func(ctx context.Context) (*xelon.PersistentStorage, error)) *operation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire()
+	if op, ok := t.operations[key]; ok {
+		return op
+	}
+
+	op := &operation{
+		done:      make(chan struct{}),
+		startedAt: time.Now(),
+	}
+	t.operations[key] = op
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+		defer cancel()
+
+		op.storage, op.err = fn(ctx)
+		op.finishedAt = time.Now()
+		close(op.done)
+	}()
+
+	return op
+}
+
+// get returns the operation tracked under the given key, or nil if there is none.
+func (t *operationTracker) get(key string) *operation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire()
+	return t.operations[key]
+}
+
+// forget stops tracking the operation under the given key, so that the next call for the same
+// key starts from the current Xelon state.
+func (t *operationTracker) forget(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.operations, key)
+}
+
+// expire drops operations which finished more than finishedOperationRetention ago. The caller
+// must hold the lock.
+func (t *operationTracker) expire() {
+	expiry := time.Now().Add(-finishedOperationRetention)
+	for key, op := range t.operations {
+		if op.finishedBefore(expiry) {
+			delete(t.operations, key)
+		}
+	}
+}
+
+func createOperationKey(volumeName string) string {
+	return "create/" + volumeName
+}
+
+func expandOperationKey(volumeID string) string {
+	return "expand/" + volumeID
+}
@@ -0,0 +1,36 @@
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+func TestOperationTracker_expire(t *testing.T) {
+	tracker := newOperationTracker(time.Minute)
+	release := make(chan struct{})
+	running := tracker.start("running", func(ctx context.Context) (*xelon.PersistentStorage, error) {
+		<-release
+		return nil, nil
+	})
+	finished := tracker.start("finished", func(ctx context.Context) (*xelon.PersistentStorage, error) {
+		return &xelon.PersistentStorage{}, nil
+	})
+	<-finished.done
+
+	if tracker.get("finished") != finished {
+		t.Fatalf("expected recently finished operation to be kept")
+	}
+
+	// pretend the operation finished a long time ago without a call picking up its result
+	finished.finishedAt = time.Now().Add(-2 * finishedOperationRetention)
+	if tracker.get("finished") != nil {
+		t.Errorf("expected expired operation to be dropped")
+	}
+	if tracker.get("running") != running {
+		t.Errorf("expected running operation to be kept")
+	}
+	close(release)
+}
@@ -1,12 +1,24 @@
 package driver
 
+import (
+	"time"
+)
+
 // Options contains parsed CLI flags passed to the driver.
 type Options struct {
-	Endpoint       string
-	Mode           Mode
-	RescanOnResize bool
-	XelonBaseURL   string
-	XelonClientID  string
-	XelonCloudID   string
-	XelonToken     string
+	Endpoint          string
+	MaxVolumesPerNode int
+	Mode              Mode
+	RescanOnResize    bool
+	XelonBaseURL      string
+	XelonClientID     string
+	XelonCloudIDs     []string
+	XelonToken        string
+
+	XelonAPIMaxInFlight     int
+	XelonAPIMaxRetries      int
+	XelonAPIRateBurst       int
+	XelonAPIRateLimit       float64
+	XelonAPIRetryMaxBackoff time.Duration
+	XelonAPIRetryMinBackoff time.Duration
 }
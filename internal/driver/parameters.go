@@ -0,0 +1,341 @@
+package driver
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+const (
+	// parameterCloudID overrides the Xelon cloud in which a new persistent storage is created.
+	parameterCloudID = "cloudId"
+	// parameterFsType is the filesystem type the node service mounts the volume with.
+	parameterFsType = "fsType"
+	// parameterNamePrefix is prepended to the volume name to build the persistent storage name.
+	parameterNamePrefix = "namePrefix"
+	// parameterReservedBlocksPercentage is the percentage of blocks reserved for root (ext4).
+	parameterReservedBlocksPercentage = "reservedBlocksPercentage"
+	// parameterStorageType is the Xelon storage type (performance tier) of a new persistent storage.
+	parameterStorageType = "storageType"
+
+	// reservedParameterPrefix is used by the external-provisioner for its own parameters (e.g.
+	// csi.storage.k8s.io/pvc/name with --extra-create-metadata). Such parameters are ignored.
+	reservedParameterPrefix = "csi.storage.k8s.io/"
+
+	// volume context keys to pass parsed parameters from the controller to the node service
+	volumeContextCloudID                  = DefaultDriverName + "/cloud-id"
+	volumeContextFsType                   = DefaultDriverName + "/fs-type"
+	volumeContextReservedBlocksPercentage = DefaultDriverName + "/reserved-blocks-percentage"
+	volumeContextStorageType              = DefaultDriverName + "/storage-type"
+
+	defaultFsType      = "ext4"
+	defaultStorageType = 2
+
+	maxNamePrefixLength = 32
+
+	maxReservedBlocksPercentage = 50
+)
+
+var (
+	supportedParameters = []string{
+		parameterCloudID,
+		parameterFsType,
+		parameterNamePrefix,
+		parameterReservedBlocksPercentage,
+		parameterStorageType,
+	}
+
+	// supportedFsTypes only contains ext4: Xelon formats every persistent storage with ext4
+	// before it can be attached, and the node service finds the device by the UUID of that
+	// filesystem, so a volume can't be reformatted with another filesystem.
+	supportedFsTypes = []string{
+		defaultFsType,
+	}
+
+	// supportedStorageTypes are the storage types (performance tiers) Xelon offers for persistent
+	// storages. The Xelon API accepts other numbers, but fails to provision such storages.
+	supportedStorageTypes = []int{1, 2}
+
+	namePrefixRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+	// volumeNameRegexp matches the names of persistent storages created by the driver: the
+	// optional namePrefix parameter followed by the name the external-provisioner generates for
+	// a PersistentVolume (pvc-<uuid> with its default --volume-name-prefix).
+	volumeNameRegexp = regexp.MustCompile(`^([a-z0-9][a-z0-9-]*)?pvc-[0-9a-f-]+$`)
+
+	// formatParameters tune the filesystem of a volume. Xelon formats every persistent storage
+	// before it can be attached, so only options which can be changed on an existing filesystem
+	// are supported. They are validated by the controller and validated again by the node service
+	// before they end up on a command line.
+	formatParameters = map[string]formatParameter{
+		parameterReservedBlocksPercentage: {
+			volumeContextKey: volumeContextReservedBlocksPercentage,
+			fsTypes:          []string{defaultFsType},
+			parse:            parseReservedBlocksPercentage,
+			tune2fsArgs: func(value string) []string {
+				return []string{"-m", value}
+			},
+		},
+	}
+)
+
+// formatParameter is a StorageClass parameter which tunes the filesystem of a volume.
+type formatParameter struct {
+	// volumeContextKey is the key the parameter is passed to the node service with
+	volumeContextKey string
+	// fsTypes are the filesystems which support the parameter
+	fsTypes []string
+	// parse validates the value and returns it normalized
+	parse func(value string) (string, error)
+	// tune2fsArgs returns the tune2fs options for the value
+	tune2fsArgs func(value string) []string
+}
+
+// volumeParameters contains the parsed StorageClass parameters of a volume.
+type volumeParameters struct {
+	CloudID     string
+	FsType      string
+	NamePrefix  string
+	StorageType int
+
+	// FormatOptions are the normalized format parameters, keyed by parameter name.
+	FormatOptions map[string]string
+}
+
+// parseVolumeParameters validates the given StorageClass parameters and returns them as
+// volumeParameters. Parameters which are not set fall back to their defaults, CloudID stays
+// empty if not set.
+func parseVolumeParameters(parameters map[string]string) (*volumeParameters, error) {
+	p := &volumeParameters{
+		FsType:        defaultFsType,
+		StorageType:   defaultStorageType,
+		FormatOptions: make(map[string]string),
+	}
+
+	for key, value := range parameters {
+		if strings.HasPrefix(key, reservedParameterPrefix) {
+			continue
+		}
+
+		switch key {
+		case parameterCloudID:
+			if _, err := strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for parameter %s, must be a numeric cloud id", value, key)
+			}
+			p.CloudID = value
+		case parameterFsType:
+			fsType, err := parseFsType(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for parameter %s: %w", value, key, err)
+			}
+			p.FsType = fsType
+		case parameterNamePrefix:
+			if len(value) > maxNamePrefixLength || !namePrefixRegexp.MatchString(value) {
+				return nil, fmt.Errorf("invalid value %q for parameter %s, must consist of at most %d lower case alphanumeric characters or '-'", value, key, maxNamePrefixLength)
+			}
+			p.NamePrefix = value
+		case parameterStorageType:
+			storageType, err := parseStorageType(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for parameter %s: %w", value, key, err)
+			}
+			p.StorageType = storageType
+		default:
+			formatParameter, ok := formatParameters[key]
+			if !ok {
+				return nil, fmt.Errorf("unknown parameter %q, supported parameters are: %s", key, strings.Join(sortedParameters(), ", "))
+			}
+			normalized, err := formatParameter.parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for parameter %s: %w", value, key, err)
+			}
+			p.FormatOptions[key] = normalized
+		}
+	}
+
+	return p, nil
+}
+
+// parseCreateVolumeParameters validates the StorageClass parameters of a new volume with the
+// given capabilities and returns them with the resolved filesystem type.
+func parseCreateVolumeParameters(parameters map[string]string, capabilities []*csi.VolumeCapability) (*volumeParameters, error) {
+	p, err := parseVolumeParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.resolveFsType(parameters, capabilities); err != nil {
+		return nil, err
+	}
+	if err = p.checkFormatOptions(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// volumeContext returns parameters which are relevant for the node service. The result is
+// passed as csi.Volume.VolumeContext and given back to the node in NodeStageVolume.
+func (p *volumeParameters) volumeContext(cloudID string) map[string]string {
+	volumeContext := map[string]string{
+		volumeContextCloudID:     cloudID,
+		volumeContextFsType:      p.FsType,
+		volumeContextStorageType: strconv.Itoa(p.StorageType),
+	}
+	for key, value := range p.FormatOptions {
+		volumeContext[formatParameters[key].volumeContextKey] = value
+	}
+	return volumeContext
+}
+
+// checkFormatOptions verifies that the resolved filesystem supports the format parameters.
+func (p *volumeParameters) checkFormatOptions() error {
+	for key := range p.FormatOptions {
+		if !slices.Contains(formatParameters[key].fsTypes, p.FsType) {
+			return fmt.Errorf("parameter %s is not supported for filesystem %s, only for: %s", key, p.FsType, strings.Join(formatParameters[key].fsTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// formatOptions returns the tune2fs options for the format parameters of the volume context. The
+// values are validated again, as the volume context of statically provisioned volumes does not
+// come from the controller.
+func formatOptions(fsType string, volumeContext map[string]string) (tune2fsArgs []string, err error) {
+	for _, key := range sortedParameters() {
+		formatParameter, ok := formatParameters[key]
+		if !ok {
+			continue
+		}
+		value, ok := volumeContext[formatParameter.volumeContextKey]
+		if !ok {
+			continue
+		}
+		if !slices.Contains(formatParameter.fsTypes, fsType) {
+			return nil, fmt.Errorf("%s is not supported for filesystem %s", formatParameter.volumeContextKey, fsType)
+		}
+		normalized, err := formatParameter.parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in volume context: %w", formatParameter.volumeContextKey, err)
+		}
+		tune2fsArgs = append(tune2fsArgs, formatParameter.tune2fsArgs(normalized)...)
+	}
+	return tune2fsArgs, nil
+}
+
+// checkVolumeContext verifies that the fs types of the capabilities match the fs type recorded in
+// the volume context, and that the format parameters of the volume context are valid.
+func checkVolumeContext(volumeContext map[string]string, capabilities []*csi.VolumeCapability) error {
+	fsType, err := volumeFsType(nil, volumeContext)
+	if err != nil {
+		return err
+	}
+	if _, ok := volumeContext[volumeContextFsType]; ok {
+		for _, capability := range capabilities {
+			value := capability.GetMount().GetFsType()
+			if value == "" {
+				continue
+			}
+			capabilityFsType, err := parseFsType(value)
+			if err != nil {
+				return fmt.Errorf("invalid fs type %q of volume capability: %w", value, err)
+			}
+			if capabilityFsType != fsType {
+				return fmt.Errorf("fs type %s of volume capability conflicts with fs type %s of the volume", capabilityFsType, fsType)
+			}
+		}
+	}
+	_, err = formatOptions(fsType, volumeContext)
+	return err
+}
+
+// resolveFsType determines the filesystem type of a new volume. An explicit fsType parameter takes
+// precedence over the fs type of the mount capabilities, which the external-provisioner fills
+// from the csi.storage.k8s.io/fstype parameter or its --default-fstype flag.
+func (p *volumeParameters) resolveFsType(parameters map[string]string, capabilities []*csi.VolumeCapability) error {
+	if _, ok := parameters[parameterFsType]; ok {
+		return nil
+	}
+
+	var capabilityFsType string
+	for _, capability := range capabilities {
+		value := capability.GetMount().GetFsType()
+		if value == "" {
+			continue
+		}
+		fsType, err := parseFsType(value)
+		if err != nil {
+			return fmt.Errorf("invalid fs type %q of volume capability: %w", value, err)
+		}
+		if capabilityFsType != "" && capabilityFsType != fsType {
+			return fmt.Errorf("volume capabilities request different fs types %s and %s", capabilityFsType, fsType)
+		}
+		capabilityFsType = fsType
+	}
+	if capabilityFsType != "" {
+		p.FsType = capabilityFsType
+	}
+	return nil
+}
+
+// volumeFsType returns the filesystem type a volume is staged with on the node. The fs type the
+// controller recorded in the volume context takes precedence, statically provisioned volumes
+// fall back to the fs type of the capability.
+func volumeFsType(capability *csi.VolumeCapability, volumeContext map[string]string) (string, error) {
+	if value := volumeContext[volumeContextFsType]; value != "" {
+		fsType, err := parseFsType(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s in volume context: %w", volumeContextFsType, err)
+		}
+		return fsType, nil
+	}
+	if value := capability.GetMount().GetFsType(); value != "" {
+		fsType, err := parseFsType(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid fs type %q of volume capability: %w", value, err)
+		}
+		return fsType, nil
+	}
+	return defaultFsType, nil
+}
+
+// parseStorageType returns the storage type if it is supported by Xelon.
+func parseStorageType(value string) (int, error) {
+	storageType, err := strconv.Atoi(value)
+	if err == nil && slices.Contains(supportedStorageTypes, storageType) {
+		return storageType, nil
+	}
+	types := make([]string, 0, len(supportedStorageTypes))
+	for _, supportedStorageType := range supportedStorageTypes {
+		types = append(types, strconv.Itoa(supportedStorageType))
+	}
+	return 0, fmt.Errorf("unsupported storage type, supported types are: %s", strings.Join(types, ", "))
+}
+
+// parseFsType returns the normalized filesystem type if it is supported by the driver.
+func parseFsType(fsType string) (string, error) {
+	fsType = strings.ToLower(fsType)
+	for _, supportedFsType := range supportedFsTypes {
+		if fsType == supportedFsType {
+			return fsType, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported filesystem type, supported types are: %s", strings.Join(supportedFsTypes, ", "))
+}
+
+func parseReservedBlocksPercentage(value string) (string, error) {
+	percentage, err := strconv.Atoi(value)
+	if err != nil || percentage < 0 || percentage > maxReservedBlocksPercentage {
+		return "", fmt.Errorf("must be an integer between 0 and %d", maxReservedBlocksPercentage)
+	}
+	return strconv.Itoa(percentage), nil
+}
+
+func sortedParameters() []string {
+	parameters := append([]string(nil), supportedParameters...)
+	sort.Strings(parameters)
+	return parameters
+}
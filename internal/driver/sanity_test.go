@@ -0,0 +1,29 @@
+package driver
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/kubernetes-csi/csi-test/v5/pkg/sanity"
+)
+
+// TestSanity runs the spec conformance tests of csi-test against the driver in the integration
+// harness, i.e. with the fake Xelon backend, a fake mounter and fake block devices.
+func TestSanity(t *testing.T) {
+	// csi-test names its volumes sanity-<suffix> instead of pvc-<uuid> like the external-provisioner
+	nameRegexp := volumeNameRegexp
+	volumeNameRegexp = regexp.MustCompile(`^sanity`)
+	t.Cleanup(func() {
+		volumeNameRegexp = nameRegexp
+	})
+
+	h := newIntegrationHarness(t)
+
+	config := sanity.NewTestConfig()
+	config.Address = "unix://" + filepath.Join(h.dir, "csi.sock")
+	config.StagingPath = filepath.Join(h.dir, "csi-staging")
+	config.TargetPath = filepath.Join(h.dir, "csi-mount")
+
+	sanity.Test(t, config)
+}
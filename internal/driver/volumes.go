@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"sync"
+)
+
+// createdVolumes keeps track of the volume context volumes were created with. Xelon doesn't record
+// the cloud or the parameters of a persistent storage, so the controller relies on them to answer
+// a repeated CreateVolume call with the same volume, or with AlreadyExists if it asks for another
+// one. The volume contexts are kept in memory only: after a restart, the volume context of an
+// existing volume is taken from the first CreateVolume call which finds it.
+type createdVolumes struct {
+	mu sync.Mutex
+	// volumes maps volume names to the volume id and volume context
+	volumes map[string]createdVolume
+}
+
+type createdVolume struct {
+	volumeID      string
+	volumeContext map[string]string
+}
+
+func newCreatedVolumes() *createdVolumes {
+	return &createdVolumes{
+		volumes: make(map[string]createdVolume),
+	}
+}
+
+// get returns the volume context the volume with the given name was created with, if it is known.
+func (c *createdVolumes) get(volumeName string) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	volume, ok := c.volumes[volumeName]
+	return volume.volumeContext, ok
+}
+
+// set records the volume context the volume with the given name was created with.
+func (c *createdVolumes) set(volumeName, volumeID string, volumeContext map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.volumes[volumeName] = createdVolume{
+		volumeID:      volumeID,
+		volumeContext: volumeContext,
+	}
+}
+
+// remove forgets the volume with the given id after it was deleted.
+func (c *createdVolumes) remove(volumeID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, volume := range c.volumes {
+		if volume.volumeID == volumeID {
+			delete(c.volumes, name)
+		}
+	}
+}
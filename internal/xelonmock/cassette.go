@@ -0,0 +1,164 @@
+package xelonmock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// Interaction is a recorded request to the Xelon API and its response. Request headers are not
+// recorded, so cassettes never contain access tokens.
+type Interaction struct {
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	RequestBody  string `json:"requestBody,omitempty"`
+	StatusCode   int    `json:"statusCode"`
+	ResponseBody string `json:"responseBody,omitempty"`
+}
+
+// Cassette is an ordered list of interactions with the Xelon API.
+type Cassette struct {
+	mu           sync.Mutex
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads a cassette from the given file.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cassette := &Cassette{}
+	if err = json.Unmarshal(data, cassette); err != nil {
+		return nil, fmt.Errorf("failed to decode cassette %s: %w", path, err)
+	}
+	return cassette, nil
+}
+
+// Save writes the cassette to the given file.
+func (c *Cassette) Save(path string) error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func (c *Cassette) add(interaction Interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Interactions = append(c.Interactions, interaction)
+}
+
+// NewRecorder returns a handler which proxies requests to the Xelon API at target and records
+// every interaction to the cassette. Paths are recorded relative to the path of target.
+func NewRecorder(target *url.URL, c *Cassette) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+		},
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		responseBody, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+		interaction := resp.Request.Context().Value(interactionKey{}).(*Interaction)
+		interaction.StatusCode = resp.StatusCode
+		interaction.ResponseBody = string(responseBody)
+		c.add(*interaction)
+		klog.V(5).InfoS("Recorded interaction", "method", interaction.Method, "path", interaction.Path, "statusCode", interaction.StatusCode)
+		return nil
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(requestBody))
+
+		interaction := &Interaction{
+			Method:      r.Method,
+			Path:        requestPath(r),
+			RequestBody: string(requestBody),
+		}
+		proxy.ServeHTTP(w, r.WithContext(contextWithInteraction(r, interaction)))
+	})
+}
+
+// NewReplayer returns a handler which responds with the interactions of the cassette. Requests
+// are matched by method and path (including the query). Repeated requests are answered with the
+// recorded interactions in order, and the last one is repeated once all of them were replayed,
+// so polling for asynchronous storage states replays the recorded progression. Requests without
+// recorded interaction fail with 500.
+func NewReplayer(c *Cassette) http.Handler {
+	c.mu.Lock()
+	interactions := make(map[string][]Interaction)
+	for _, interaction := range c.Interactions {
+		key := interaction.Method + " " + interaction.Path
+		interactions[key] = append(interactions[key], interaction)
+	}
+	c.mu.Unlock()
+
+	var mu sync.Mutex
+	replayed := make(map[string]int)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + requestPath(r)
+
+		mu.Lock()
+		recorded := interactions[key]
+		i := replayed[key]
+		if i < len(recorded)-1 {
+			replayed[key]++
+		}
+		mu.Unlock()
+
+		if len(recorded) == 0 {
+			klog.InfoS("No recorded interaction for request", "method", r.Method, "path", requestPath(r))
+			writeJSON(w, http.StatusInternalServerError, &errorElement{
+				Code:  http.StatusInternalServerError,
+				Error: "no recorded interaction for " + key,
+			})
+			return
+		}
+
+		interaction := recorded[i]
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(interaction.StatusCode)
+		_, _ = io.WriteString(w, interaction.ResponseBody)
+	})
+}
+
+type interactionKey struct{}
+
+func contextWithInteraction(r *http.Request, interaction *Interaction) context.Context {
+	return context.WithValue(r.Context(), interactionKey{}, interaction)
+}
+
+// requestPath returns the path of the request relative to the root path, including the query.
+func requestPath(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+	return path
+}
@@ -0,0 +1,80 @@
+package xelonmock
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud/fake"
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+func TestCassette_recordAndReplay(t *testing.T) {
+	target, _ := newTestServer(t, NewHandler(newTestBackend()))
+	targetURL, err := url.Parse(target.URL + testBasePath + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// record creating a persistent storage and polling until it is formatted
+	recorded := &Cassette{}
+	_, provider := newTestServer(t, NewRecorder(targetURL, recorded))
+	ctx := context.Background()
+	created, err := provider.CreatePersistentStorage(ctx, fake.DefaultTenantID, &xelon.PersistentStorageCreateRequest{
+		PersistentStorage: &xelon.PersistentStorage{Name: "pvc-1", Type: 2},
+		CloudID:           "1",
+		Size:              10,
+	})
+	if err != nil {
+		t.Fatalf("CreatePersistentStorage: unexpected error: %v", err)
+	}
+	localID := created.PersistentStorage.LocalID
+	if storage, err := provider.GetPersistentStorage(ctx, fake.DefaultTenantID, localID); err != nil || storage.Formatted != 0 {
+		t.Fatalf("GetPersistentStorage: expected storage to be formatting, got %+v (%v)", storage, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if storage, err := provider.GetPersistentStorage(ctx, fake.DefaultTenantID, localID); err != nil || storage.Formatted != 1 {
+		t.Fatalf("GetPersistentStorage: expected formatted storage, got %+v (%v)", storage, err)
+	}
+
+	if len(recorded.Interactions) != 3 {
+		t.Fatalf("expected 3 recorded interactions, got %+v", recorded.Interactions)
+	}
+	createInteraction := recorded.Interactions[0]
+	if createInteraction.Method != http.MethodPost || createInteraction.Path != fake.DefaultTenantID+"/persistentStorage" ||
+		!strings.Contains(createInteraction.RequestBody, `"pvc-1"`) {
+		t.Errorf("unexpected create interaction %+v", createInteraction)
+	}
+
+	file := filepath.Join(t.TempDir(), "cassette.json")
+	if err = recorded.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCassette(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replay the recorded progression, repeating the last state
+	_, provider = newTestServer(t, NewReplayer(loaded))
+	if _, err = provider.CreatePersistentStorage(ctx, fake.DefaultTenantID, &xelon.PersistentStorageCreateRequest{
+		PersistentStorage: &xelon.PersistentStorage{Name: "pvc-1", Type: 2},
+		CloudID:           "1",
+		Size:              10,
+	}); err != nil {
+		t.Fatalf("CreatePersistentStorage: unexpected error on replay: %v", err)
+	}
+	for i, formatted := range []int{0, 1, 1} {
+		storage, err := provider.GetPersistentStorage(ctx, fake.DefaultTenantID, localID)
+		if err != nil || storage.Formatted != formatted {
+			t.Fatalf("GetPersistentStorage %d: expected formatted %d, got %+v (%v)", i, formatted, storage, err)
+		}
+	}
+
+	_, err = provider.GetPersistentStorage(ctx, fake.DefaultTenantID, "unknown")
+	assertStatusCode(t, err, http.StatusInternalServerError)
+}
@@ -0,0 +1,185 @@
+// Package xelonmock provides HTTP stand-ins for the Xelon service API, so that the driver binary
+// can run against the API without access to Xelon, e.g. in kind clusters. NewHandler simulates
+// the API with an in-memory backend, NewRecorder records the interactions with the real API to a
+// cassette and NewReplayer replays them.
+package xelonmock
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"k8s.io/klog/v2"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud/fake"
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+const persistentStoragePath = "persistentStorage"
+
+// errorElement is the error body of the Xelon API.
+type errorElement struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
+
+type simulator struct {
+	provider *fake.Provider
+}
+
+// NewHandler returns a handler which serves the endpoints of the Xelon API used by the driver
+// from the given backend, relative to the root path. Persistent storages are created and
+// extended asynchronously as configured in the backend, so their uuid is empty and formatted is
+// 0 until Xelon would have finished formatting them.
+func NewHandler(p *fake.Provider) http.Handler {
+	s := &simulator{provider: p}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tenant", s.getCurrentTenant)
+	mux.HandleFunc("GET /hv/list/{tenant}", s.listClouds)
+	mux.HandleFunc("GET /device", s.getDevice)
+	mux.HandleFunc("GET /{tenant}/persistentStorage", s.listPersistentStorages)
+	mux.HandleFunc("GET /{tenant}/persistentStorage/query", s.getPersistentStorageByName)
+	mux.HandleFunc("GET /{tenant}/persistentStorage/{localID}", s.getPersistentStorage)
+	mux.HandleFunc("DELETE /{tenant}/persistentStorage/{localID}", s.deletePersistentStorage)
+	mux.HandleFunc("POST /{tenant}/persistentStorage/{localID}/addToVirtualMachine", s.attachPersistentStorage)
+	mux.HandleFunc("POST /{tenant}/persistentStorage/{localID}/removeFromVirtualMachine", s.detachPersistentStorage)
+	// creating ({tenant}/persistentStorage) and extending (persistentStorage/{localID}) share
+	// the same pattern, which the mux cannot tell apart
+	mux.HandleFunc("POST /{first}/{second}", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.PathValue("first") == persistentStoragePath:
+			s.extendPersistentStorage(w, r)
+		case r.PathValue("second") == persistentStoragePath:
+			s.createPersistentStorage(w, r)
+		default:
+			writeError(w, http.StatusNotFound)
+		}
+	})
+
+	return requireAuthorization(mux)
+}
+
+// requireAuthorization rejects requests without the bearer token the SDK always sends.
+func requireAuthorization(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			writeError(w, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *simulator) getCurrentTenant(w http.ResponseWriter, r *http.Request) {
+	tenant, err := s.provider.GetCurrentTenant(r.Context())
+	writeResponse(w, tenant, err)
+}
+
+func (s *simulator) listClouds(w http.ResponseWriter, r *http.Request) {
+	clouds, err := s.provider.ListClouds(r.Context(), r.PathValue("tenant"))
+	writeResponse(w, clouds, err)
+}
+
+func (s *simulator) getDevice(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	device, err := s.provider.GetDevice(r.Context(), query.Get("tenant"), query.Get("localvmid"))
+	writeResponse(w, device, err)
+}
+
+func (s *simulator) listPersistentStorages(w http.ResponseWriter, r *http.Request) {
+	storages, err := s.provider.ListPersistentStorages(r.Context(), r.PathValue("tenant"))
+	writeResponse(w, storages, err)
+}
+
+func (s *simulator) getPersistentStorageByName(w http.ResponseWriter, r *http.Request) {
+	storage, err := s.provider.GetPersistentStorageByName(r.Context(), r.PathValue("tenant"), r.URL.Query().Get("name"))
+	writeResponse(w, storage, err)
+}
+
+func (s *simulator) getPersistentStorage(w http.ResponseWriter, r *http.Request) {
+	storage, err := s.provider.GetPersistentStorage(r.Context(), r.PathValue("tenant"), r.PathValue("localID"))
+	writeResponse(w, storage, err)
+}
+
+func (s *simulator) createPersistentStorage(w http.ResponseWriter, r *http.Request) {
+	createRequest := &xelon.PersistentStorageCreateRequest{}
+	if !decodeRequest(w, r, createRequest) {
+		return
+	}
+	apiResponse, err := s.provider.CreatePersistentStorage(r.Context(), r.PathValue("first"), createRequest)
+	writeResponse(w, apiResponse, err)
+}
+
+func (s *simulator) extendPersistentStorage(w http.ResponseWriter, r *http.Request) {
+	extendRequest := &xelon.PersistentStorageExtendRequest{}
+	if !decodeRequest(w, r, extendRequest) {
+		return
+	}
+	apiResponse, err := s.provider.ExtendPersistentStorage(r.Context(), r.PathValue("second"), extendRequest)
+	writeResponse(w, apiResponse, err)
+}
+
+func (s *simulator) deletePersistentStorage(w http.ResponseWriter, r *http.Request) {
+	err := s.provider.DeletePersistentStorage(r.Context(), r.PathValue("tenant"), r.PathValue("localID"))
+	writeResponse(w, nil, err)
+}
+
+func (s *simulator) attachPersistentStorage(w http.ResponseWriter, r *http.Request) {
+	attachRequest := &xelon.PersistentStorageAttachDetachRequest{}
+	if !decodeRequest(w, r, attachRequest) {
+		return
+	}
+	apiResponse, err := s.provider.AttachPersistentStorage(r.Context(), r.PathValue("tenant"), r.PathValue("localID"), attachRequest)
+	writeResponse(w, apiResponse, err)
+}
+
+func (s *simulator) detachPersistentStorage(w http.ResponseWriter, r *http.Request) {
+	detachRequest := &xelon.PersistentStorageAttachDetachRequest{}
+	if !decodeRequest(w, r, detachRequest) {
+		return
+	}
+	apiResponse, err := s.provider.DetachPersistentStorage(r.Context(), r.PathValue("tenant"), r.PathValue("localID"), detachRequest)
+	writeResponse(w, apiResponse, err)
+}
+
+// decodeRequest decodes the JSON body of the request into v and responds with 400 if the body is
+// malformed.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		klog.V(2).InfoS("Failed to decode request body", "error", err, "method", r.Method, "path", r.URL.Path)
+		writeError(w, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeResponse writes v as JSON body, or the status code of the backend error.
+func writeResponse(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		var errorResponse *xelon.ErrorResponse
+		if errors.As(err, &errorResponse) {
+			statusCode = errorResponse.Response.StatusCode
+		}
+		writeError(w, statusCode)
+		return
+	}
+	if v == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int) {
+	writeJSON(w, statusCode, &errorElement{Code: statusCode, Error: http.StatusText(statusCode)})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		klog.V(2).InfoS("Failed to write response body", "error", err)
+	}
+}
@@ -0,0 +1,131 @@
+package xelonmock
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud"
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud/fake"
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+const testBasePath = "/api/service"
+
+// newTestServer serves the handler under the base path of the Xelon API and returns a provider
+// which talks to it with the Xelon SDK.
+func newTestServer(t *testing.T, handler http.Handler) (*httptest.Server, cloud.Provider) {
+	t.Helper()
+
+	server := httptest.NewServer(http.StripPrefix(testBasePath, handler))
+	t.Cleanup(server.Close)
+
+	provider, err := cloud.NewXelonProvider("token", "client", server.URL+testBasePath+"/", "test", cloud.TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, provider
+}
+
+func newTestBackend() *fake.Provider {
+	p := fake.NewProvider()
+	p.FormattingDuration = 50 * time.Millisecond
+	state := &State{
+		Clouds:  []Cloud{{ID: 1, Name: "cloud-1"}},
+		Devices: []Device{{LocalVMID: "dev-1", CloudID: 1, PoweredOn: true}},
+	}
+	state.Apply(p)
+	return p
+}
+
+func TestNewHandler(t *testing.T) {
+	_, provider := newTestServer(t, NewHandler(newTestBackend()))
+	ctx := context.Background()
+
+	tenant, err := provider.GetCurrentTenant(ctx)
+	if err != nil || tenant.TenantID != fake.DefaultTenantID {
+		t.Fatalf("GetCurrentTenant: expected tenant %s, got %+v (%v)", fake.DefaultTenantID, tenant, err)
+	}
+	clouds, err := provider.ListClouds(ctx, tenant.TenantID)
+	if err != nil || len(clouds) != 1 || clouds[0].ID != 1 {
+		t.Fatalf("ListClouds: expected cloud 1, got %+v (%v)", clouds, err)
+	}
+	device, err := provider.GetDevice(ctx, tenant.TenantID, "dev-1")
+	if err != nil || device.Device.LocalVMDetails.HVSystemID != 1 || !device.Device.PowerState {
+		t.Fatalf("GetDevice: expected powered on device in cloud 1, got %+v (%v)", device, err)
+	}
+
+	created, err := provider.CreatePersistentStorage(ctx, tenant.TenantID, &xelon.PersistentStorageCreateRequest{
+		PersistentStorage: &xelon.PersistentStorage{Name: "pvc-1", Type: 2},
+		CloudID:           "1",
+		Size:              10,
+	})
+	if err != nil {
+		t.Fatalf("CreatePersistentStorage: unexpected error: %v", err)
+	}
+	localID := created.PersistentStorage.LocalID
+	if created.PersistentStorage.UUID != "" || created.PersistentStorage.Formatted != 0 {
+		t.Errorf("CreatePersistentStorage: expected storage to be formatting, got %+v", created.PersistentStorage)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	storage, err := provider.GetPersistentStorageByName(ctx, tenant.TenantID, "pvc-1")
+	if err != nil || storage.LocalID != localID || storage.UUID == "" || storage.Formatted != 1 || storage.Capacity != 10 {
+		t.Fatalf("GetPersistentStorageByName: expected formatted storage %s, got %+v (%v)", localID, storage, err)
+	}
+
+	if _, err = provider.AttachPersistentStorage(ctx, tenant.TenantID, localID, &xelon.PersistentStorageAttachDetachRequest{ServerID: []string{"dev-1"}}); err != nil {
+		t.Fatalf("AttachPersistentStorage: unexpected error: %v", err)
+	}
+	storages, err := provider.ListPersistentStorages(ctx, tenant.TenantID)
+	if err != nil || len(storages) != 1 || len(storages[0].AssignedServers) != 1 {
+		t.Fatalf("ListPersistentStorages: expected attached storage, got %+v (%v)", storages, err)
+	}
+	err = provider.DeletePersistentStorage(ctx, tenant.TenantID, localID)
+	assertStatusCode(t, err, http.StatusUnprocessableEntity)
+
+	if _, err = provider.DetachPersistentStorage(ctx, tenant.TenantID, localID, &xelon.PersistentStorageAttachDetachRequest{ServerID: []string{"dev-1"}}); err != nil {
+		t.Fatalf("DetachPersistentStorage: unexpected error: %v", err)
+	}
+	if _, err = provider.ExtendPersistentStorage(ctx, localID, &xelon.PersistentStorageExtendRequest{Size: 20}); err != nil {
+		t.Fatalf("ExtendPersistentStorage: unexpected error: %v", err)
+	}
+	storage, err = provider.GetPersistentStorage(ctx, tenant.TenantID, localID)
+	if err != nil || storage.Capacity != 10 {
+		t.Fatalf("GetPersistentStorage: expected previous capacity while extending, got %+v (%v)", storage, err)
+	}
+
+	if err = provider.DeletePersistentStorage(ctx, tenant.TenantID, localID); err != nil {
+		t.Fatalf("DeletePersistentStorage: unexpected error: %v", err)
+	}
+	_, err = provider.GetPersistentStorage(ctx, tenant.TenantID, localID)
+	assertStatusCode(t, err, http.StatusNotFound)
+}
+
+func TestNewHandler_unauthorized(t *testing.T) {
+	server, _ := newTestServer(t, NewHandler(newTestBackend()))
+
+	resp, err := http.Get(server.URL + testBasePath + "/tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status code %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func assertStatusCode(t *testing.T, err error, statusCode int) {
+	t.Helper()
+
+	var errorResponse *xelon.ErrorResponse
+	if !errors.As(err, &errorResponse) {
+		t.Fatalf("expected *xelon.ErrorResponse, got %T (%v)", err, err)
+	}
+	if errorResponse.Response.StatusCode != statusCode {
+		t.Errorf("expected status code %d, got %d", statusCode, errorResponse.Response.StatusCode)
+	}
+}
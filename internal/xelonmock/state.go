@@ -0,0 +1,56 @@
+package xelonmock
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Xelon-AG/xelon-csi/internal/driver/cloud/fake"
+	"github.com/Xelon-AG/xelon-sdk-go/xelon"
+)
+
+// State is the initial content of a simulated Xelon backend.
+type State struct {
+	Clouds             []Cloud                   `json:"clouds,omitempty"`
+	Devices            []Device                  `json:"devices,omitempty"`
+	PersistentStorages []xelon.PersistentStorage `json:"persistentStorages,omitempty"`
+}
+
+// Cloud is a cloud accessible by the tenant.
+type Cloud struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Device is a device of the tenant, typically a node of the cluster.
+type Device struct {
+	LocalVMID string `json:"localVMID"`
+	CloudID   int    `json:"cloudID"`
+	PoweredOn bool   `json:"poweredOn"`
+}
+
+// LoadState reads a state from the given JSON file.
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to decode state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// Apply adds the clouds, devices and persistent storages of the state to the backend.
+func (s *State) Apply(p *fake.Provider) {
+	for _, c := range s.Clouds {
+		p.AddCloud(c.ID, c.Name)
+	}
+	for _, d := range s.Devices {
+		p.AddDevice(d.LocalVMID, d.CloudID, d.PoweredOn)
+	}
+	for _, storage := range s.PersistentStorages {
+		p.AddPersistentStorage(storage)
+	}
+}